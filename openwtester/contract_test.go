@@ -16,11 +16,9 @@
 package openwtester
 
 import (
-	"encoding/hex"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"github.com/golang/protobuf/proto"
-	"github.com/xuperchain/xuperchain/core/pb"
+	"github.com/blocktree/xuperchain-adapter/xuperchain"
 	"io/ioutil"
 	"path/filepath"
 	"testing"
@@ -85,32 +83,26 @@ func TestDeployWasmContract(t *testing.T) {
 	//accountID := "9vEjJLbcrP1bg1MJRTAbPYe4ZwDxamawhPCVEXPi3CMq"
 	accountID := "FAyzXxWhEfQ6rWJpNL6agYd9EBvgsKcKgzEWGPgjDbkF"
 	contract := openwallet.SmartContract{
-		Address: "xkernel",
+		Address: xuperchain.KernelContractAddress,
 		Symbol:  "XUPER",
 	}
-	contract.SetABI(`[{"constant":false,"inputs":[{"name":"account_name","type":"string"},{"name":"contract_name","type":"string"},{"name":"contract_code","type":"bytes"},{"name":"contract_desc","type":"bytes"},{"name":"init_args","type":"string"}],"name":"Deploy","outputs":[],"payable":false,"type":"function"}]`)
-	accountName := "XC3333333333333333@xuper"
-	contractName := "artToyContract2"
+	contract.SetABI(xuperchain.DeployABI)
 	contractCode, ioErr := ioutil.ReadFile(filepath.Join("openw_data", "wasm", "artToyContract.wasm"))
 	if ioErr != nil {
 		t.Errorf("get wasm contract code error: %v", ioErr)
 		return
 	}
-	desc := &pb.WasmCodeDesc{
-		Runtime: "go",
-	}
-	contractDesc, _ := proto.Marshal(desc)
-
-	//initarg := `{"initSupply":"` + base64.StdEncoding.EncodeToString([]byte("20000000000")) + `"}`
-	initarg := `{}`
 
-	callParam := []string{
-		"Deploy",
-		accountName,
-		contractName,
-		hex.EncodeToString(contractCode),
-		hex.EncodeToString(contractDesc),
-		initarg,
+	callParam, paramErr := xuperchain.NewWasmDeployABIParam(&xuperchain.WasmDeployParam{
+		AccountName:  "XC3333333333333333@xuper",
+		ContractName: "artToyContract2",
+		Code:         contractCode,
+		Runtime:      xuperchain.WASM_RUNTIME_GO,
+		//InitArgs: map[string]string{"initSupply": "20000000000"},
+	})
+	if paramErr != nil {
+		t.Errorf("NewWasmDeployABIParam failed, unexpected error: %v", paramErr)
+		return
 	}
 
 	rawTx, err := tm.CreateSmartContractTransaction(testApp, walletID, accountID, "", "", &contract, callParam)
@@ -0,0 +1,373 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+//parseABIParam 解析abi调用的string参数，数组、切片和元组类型的参数使用json传入
+func parseABIParam(t abi.Type, param string) (interface{}, error) {
+	switch t.T {
+	case abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		decoder := json.NewDecoder(strings.NewReader(param))
+		decoder.UseNumber()
+		var raw interface{}
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("%s json is invalid: %v", t.String(), err)
+		}
+		return raw, nil
+	}
+	return param, nil
+}
+
+//packABIValue 转化参数为solidity abi类型的go值，用于evm合约编码
+//path为参数路径，用于错误提示
+func packABIValue(t abi.Type, raw interface{}, path string) (interface{}, error) {
+
+	switch t.T {
+	case abi.BoolTy:
+		return parseABIBool(raw, path)
+	case abi.IntTy, abi.UintTy:
+		num, err := parseABIInt(t, raw, path)
+		if err != nil {
+			return nil, err
+		}
+		//8、16、32、64位使用对应宽度的整数类型，其余使用big.Int
+		if t.Type == reflect.TypeOf(num) {
+			return num, nil
+		}
+		if t.T == abi.IntTy {
+			return reflect.ValueOf(num.Int64()).Convert(t.Type).Interface(), nil
+		}
+		return reflect.ValueOf(num.Uint64()).Convert(t.Type).Interface(), nil
+	case abi.AddressTy:
+		s, err := abiString(raw, path)
+		if err != nil {
+			return nil, err
+		}
+		if !ethcom.IsHexAddress(s) {
+			return nil, fmt.Errorf("%s: %s is not evm address", path, s)
+		}
+		return ethcom.HexToAddress(s), nil
+	case abi.StringTy:
+		return abiString(raw, path)
+	case abi.BytesTy:
+		return parseABIBytes(t, raw, path)
+	case abi.FixedBytesTy, abi.FunctionTy, abi.HashTy:
+		b, err := parseABIBytes(t, raw, path)
+		if err != nil {
+			return nil, err
+		}
+		fixed := reflect.New(t.Type).Elem()
+		for i := range b {
+			fixed.Index(i).SetUint(uint64(b[i]))
+		}
+		return fixed.Interface(), nil
+	case abi.SliceTy, abi.ArrayTy:
+		list, err := parseABIList(t, raw, path)
+		if err != nil {
+			return nil, err
+		}
+		var value reflect.Value
+		if t.T == abi.SliceTy {
+			value = reflect.MakeSlice(t.Type, len(list), len(list))
+		} else {
+			value = reflect.New(t.Type).Elem()
+		}
+		for i, item := range list {
+			elem, err := packABIValue(*t.Elem, item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			value.Index(i).Set(reflect.ValueOf(elem))
+		}
+		return value.Interface(), nil
+	case abi.TupleTy:
+		fields, err := parseABITuple(t, raw, path)
+		if err != nil {
+			return nil, err
+		}
+		value := reflect.New(t.Type).Elem()
+		for i, elemType := range t.TupleElems {
+			elem, err := packABIValue(*elemType, fields[i], path+"."+t.TupleRawNames[i])
+			if err != nil {
+				return nil, err
+			}
+			value.Field(i).Set(reflect.ValueOf(elem))
+		}
+		return value.Interface(), nil
+	}
+
+	return nil, fmt.Errorf("%s: unsupported abi type: %s", path, t.String())
+}
+
+//encodeWasmValue 转化参数为wasm/native合约的参数字节
+//数组、切片和元组类型校验后以json传入
+func encodeWasmValue(t abi.Type, raw interface{}, path string) ([]byte, error) {
+
+	switch t.T {
+	case abi.BoolTy:
+		b, err := parseABIBool(raw, path)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return []byte{0x01}, nil
+		}
+		return []byte{0x00}, nil
+	case abi.IntTy, abi.UintTy:
+		num, err := parseABIInt(t, raw, path)
+		if err != nil {
+			return nil, err
+		}
+		//参数字节为大端编码的绝对值，无法表示负数
+		if num.Sign() < 0 {
+			return nil, fmt.Errorf("%s: negative number is not supported by wasm contract", path)
+		}
+		return num.Bytes(), nil
+	case abi.AddressTy:
+		s, err := abiString(raw, path)
+		if err != nil {
+			return nil, err
+		}
+		if len(s) == 0 {
+			return nil, fmt.Errorf("%s: address is empty", path)
+		}
+		return []byte(s), nil
+	case abi.StringTy:
+		s, err := abiString(raw, path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(s), nil
+	case abi.BytesTy, abi.FixedBytesTy, abi.FunctionTy, abi.HashTy:
+		return parseABIBytes(t, raw, path)
+	case abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		if err := validateWasmValue(t, raw, path); err != nil {
+			return nil, err
+		}
+		return json.Marshal(raw)
+	}
+
+	return nil, fmt.Errorf("%s: unsupported abi type: %s", path, t.String())
+}
+
+//validateWasmValue 校验wasm合约的复合类型参数
+func validateWasmValue(t abi.Type, raw interface{}, path string) error {
+	switch t.T {
+	case abi.SliceTy, abi.ArrayTy:
+		list, err := parseABIList(t, raw, path)
+		if err != nil {
+			return err
+		}
+		for i, item := range list {
+			if err := validateWasmValue(*t.Elem, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case abi.TupleTy:
+		fields, err := parseABITuple(t, raw, path)
+		if err != nil {
+			return err
+		}
+		for i, elemType := range t.TupleElems {
+			if err := validateWasmValue(*elemType, fields[i], path+"."+t.TupleRawNames[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case abi.IntTy, abi.UintTy:
+		//json中的数字保留符号
+		_, err := parseABIInt(t, raw, path)
+		return err
+	}
+	_, err := encodeWasmValue(t, raw, path)
+	return err
+}
+
+//abiString 参数转为字符串，json中的数字和布尔值也允许
+func abiString(raw interface{}, path string) (string, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	return "", fmt.Errorf("%s: %v is not a string value", path, raw)
+}
+
+//parseABIBool 解析布尔参数，只接受true或false
+func parseABIBool(raw interface{}, path string) (bool, error) {
+	if b, ok := raw.(bool); ok {
+		return b, nil
+	}
+	s, err := abiString(raw, path)
+	if err != nil {
+		return false, err
+	}
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s: %s is not bool", path, s)
+}
+
+//parseABIInt 解析整数参数，并按类型宽度检查范围
+func parseABIInt(t abi.Type, raw interface{}, path string) (*big.Int, error) {
+
+	s, err := abiString(raw, path)
+	if err != nil {
+		return nil, err
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	base := numBase(digits)
+	digits = strings.TrimPrefix(digits, "0x")
+	if len(digits) == 0 {
+		return nil, fmt.Errorf("%s: %s is not number", path, s)
+	}
+
+	num, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is not number", path, s)
+	}
+	if negative {
+		num.Neg(num)
+	}
+
+	var min, max *big.Int
+	if t.T == abi.UintTy {
+		min = big.NewInt(0)
+		max = new(big.Int).Lsh(big.NewInt(1), uint(t.Size))
+	} else {
+		max = new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+		min = new(big.Int).Neg(max)
+	}
+	max.Sub(max, big.NewInt(1))
+
+	if num.Cmp(min) < 0 || num.Cmp(max) > 0 {
+		return nil, fmt.Errorf("%s: %s is out of range of %s", path, s, t.String())
+	}
+
+	return num, nil
+}
+
+//parseABIBytes 解析hex编码的字节参数，定长类型需长度一致
+func parseABIBytes(t abi.Type, raw interface{}, path string) ([]byte, error) {
+
+	s, err := abiString(raw, path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s is not hex: %v", path, s, err)
+	}
+
+	switch t.T {
+	case abi.FixedBytesTy:
+		if len(b) != t.Size {
+			return nil, fmt.Errorf("%s: %s length is %d, except is %d", path, t.String(), len(b), t.Size)
+		}
+	case abi.FunctionTy:
+		if len(b) != 24 {
+			return nil, fmt.Errorf("%s: function length is %d, except is 24", path, len(b))
+		}
+	case abi.HashTy:
+		if len(b) != ethcom.HashLength {
+			return nil, fmt.Errorf("%s: hash length is %d, except is %d", path, len(b), ethcom.HashLength)
+		}
+	}
+
+	return b, nil
+}
+
+//parseABIList 解析数组或切片参数，数组需长度一致
+func parseABIList(t abi.Type, raw interface{}, path string) ([]interface{}, error) {
+
+	//嵌套的元素可能仍是json字符串
+	if s, ok := raw.(string); ok {
+		parsed, err := parseABIParam(t, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		raw = parsed
+	}
+
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: %s value must be json array", path, t.String())
+	}
+
+	if t.T == abi.ArrayTy && len(list) != t.Size {
+		return nil, fmt.Errorf("%s: %s length is %d, except is %d", path, t.String(), len(list), t.Size)
+	}
+
+	return list, nil
+}
+
+//parseABITuple 解析元组参数，支持按字段名的json对象或按顺序的json数组
+func parseABITuple(t abi.Type, raw interface{}, path string) ([]interface{}, error) {
+
+	if s, ok := raw.(string); ok {
+		parsed, err := parseABIParam(t, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		raw = parsed
+	}
+
+	switch v := raw.(type) {
+	case []interface{}:
+		if len(v) != len(t.TupleElems) {
+			return nil, fmt.Errorf("%s: tuple length is %d, except is %d", path, len(v), len(t.TupleElems))
+		}
+		return v, nil
+	case map[string]interface{}:
+		fields := make([]interface{}, len(t.TupleElems))
+		for i, name := range t.TupleRawNames {
+			field, ok := v[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: tuple field %s is missing", path, name)
+			}
+			fields[i] = field
+		}
+		if len(v) != len(t.TupleRawNames) {
+			return nil, fmt.Errorf("%s: tuple has unknown fields", path)
+		}
+		return fields, nil
+	}
+
+	return nil, fmt.Errorf("%s: tuple value must be json object or array", path)
+}
@@ -17,12 +17,16 @@ package xuperchain
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 	"github.com/xuperchain/xuperchain/core/pb"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,6 +34,24 @@ const (
 	maxExtractingSize = 10 //并发的扫描线程数
 )
 
+const (
+	FeeAddress = "$" //手续费的接收地址
+
+	tokenTransferEvent      = "transfer"     //代币转账事件
+	tokenTransferMethod     = "transfer"     //代币转账方法
+	tokenTransferFromMethod = "transferFrom" //代币授权转账方法
+
+	//openwallet的TxType中0为转账，1为合约调用，coinbase及矿工奖励交易使用下一个未占用的值2
+	TxTypeTransfer     = 0 //普通转账交易
+	TxTypeContractCall = 1 //合约调用，V1接口中由合约回执转换的交易
+	TxTypeCoinbase     = 2 //coinbase及矿工奖励交易
+
+	TxActionCoinbase = "coinbase" //coinbase交易
+	TxActionAward    = "award"    //矿工出块奖励
+
+	TxActionContractCall = "contractCall" //合约调用，V1接口中由合约回执转换的交易
+)
+
 //BlockScanner 区块链扫描器
 type BlockScanner struct {
 	*openwallet.BlockScannerBase
@@ -39,11 +61,26 @@ type BlockScanner struct {
 	wm                   *WalletManager //钱包管理者
 	IsScanMemPool        bool           //是否扫描交易池
 	RescanLastBlockCount uint64         //重扫上N个区块数量
+
+	confirmingTxs   map[string]*confirmingTx //等待确认的交易
+	confirmMu       sync.Mutex               //等待确认交易锁
+	confirmRestored bool                     //是否已从检查点重建等待确认的交易
+
+	rangeProgress BlockScanRangeProgress //范围重扫进度
+	rangeQuit     chan struct{}          //范围重扫停止信号
+	rangeMu       sync.Mutex             //范围重扫锁
+
+	scanMu        sync.Mutex    //扫描任务锁，订阅和轮询不同时扫描
+	extractMu     sync.Mutex    //提取通知锁，主扫描和范围重扫不同时提取通知区块
+	subscribed    bool          //是否由区块事件订阅驱动扫描
+	subscribeQuit chan struct{} //区块事件订阅停止信号
+	subscribeMu   sync.Mutex    //区块事件订阅锁
 }
 
 //ExtractResult 扫描完成的提取结果
 type ExtractResult struct {
 	extractData         map[string]*openwallet.TxExtractData //主链交易
+	extractTokenData    map[string][]*openwallet.TxExtractData //代币交易
 	extractContractData map[string]*openwallet.SmartContractReceipt //合约回执
 	TxID                string
 	BlockHeight         uint64
@@ -66,15 +103,21 @@ func NewBlockScanner(wm *WalletManager) *BlockScanner {
 	bs.extractingCH = make(chan struct{}, maxExtractingSize)
 	bs.wm = wm
 	bs.RescanLastBlockCount = 0
+	bs.confirmingTxs = make(map[string]*confirmingTx)
 
 	//设置扫描任务
-	bs.SetTask(bs.ScanBlockTask)
+	bs.SetTask(bs.pollBlockTask)
 
 	return &bs
 }
 
 //ScanBlockTask 扫描任务
 func (bs *BlockScanner) ScanBlockTask() {
+	bs.scanBlocks(bs.GetBlockHeight)
+}
+
+//scanBlocks 扫描到getMaxHeight返回的最大高度，订阅模式下最大高度来自区块事件
+func (bs *BlockScanner) scanBlocks(getMaxHeight func() (uint64, error)) {
 
 	//获取本地区块高度
 	blockHeader, err := bs.GetScannedBlockHeader()
@@ -85,6 +128,10 @@ func (bs *BlockScanner) ScanBlockTask() {
 
 	currentHeight := blockHeader.Height
 	currentHash := blockHeader.Hash
+	maxHeight := uint64(0)
+
+	//并发预下载后续区块，按高度顺序处理
+	prefetcher := newBlockPrefetcher(bs, bs.wm.Config.PrefetchBlockSize)
 
 	for {
 
@@ -93,18 +140,22 @@ func (bs *BlockScanner) ScanBlockTask() {
 			return
 		}
 
-		//获取最大高度
-		maxHeight, err := bs.GetBlockHeight()
-		if err != nil {
-			//下一个高度找不到会报异常
-			bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
-			break
-		}
-
-		//是否已到最新高度
+		//已追上记录的最大高度，重新获取最大高度
 		if currentHeight >= maxHeight {
-			bs.wm.Log.Std.Info("block scanner has scanned full chain data. Current height: %d", maxHeight)
-			break
+			maxHeight, err = getMaxHeight()
+			if err != nil {
+				//下一个高度找不到会报异常
+				bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
+				break
+			}
+
+			//是否已到最新高度
+			if currentHeight >= maxHeight {
+				bs.wm.Log.Std.Info("block scanner has scanned full chain data. Current height: %d", maxHeight)
+				break
+			}
+
+			prefetcher.SetMaxHeight(maxHeight)
 		}
 
 		//继续扫描下一个区块
@@ -112,7 +163,7 @@ func (bs *BlockScanner) ScanBlockTask() {
 
 		bs.wm.Log.Std.Info("block scanner scanning height: %d ...", currentHeight)
 
-		block, err := bs.wm.RPC.GetBlockByHeight(int64(currentHeight))
+		block, err := prefetcher.GetBlockByHeight(currentHeight)
 		if err != nil {
 			bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
 
@@ -137,6 +188,9 @@ func (bs *BlockScanner) ScanBlockTask() {
 			//查询本地分叉的区块
 			forkBlock, _ := bs.GetLocalBlock(currentHeight - 1)
 
+			//分叉区块上的交易不再等待确认
+			bs.removeConfirmingTxs(currentHeight - 1)
+
 			//删除上一区块链的所有充值记录
 			//bs.DeleteRechargesByHeight(currentHeight - 1)
 			//删除上一区块链的未扫记录
@@ -205,6 +259,9 @@ func (bs *BlockScanner) ScanBlockTask() {
 	//重扫失败区块
 	bs.RescanFailedRecord()
 
+	//通知达到确认深度的交易
+	bs.NotifyConfirmedTransactions()
+
 }
 
 //ScanBlock 扫描指定高度区块
@@ -312,20 +369,42 @@ func (bs *BlockScanner) newBlockNotify(block *pb.InternalBlock, isFork bool) {
 	obj.Symbol = bs.wm.Symbol()
 	obj.Fork = isFork
 	bs.NewBlockNotify(obj)
+
+	//唤醒等待回执的交易
+	if bs.wm.ReceiptWaiter != nil {
+		bs.wm.ReceiptWaiter.NotifyNewBlock()
+	}
+}
+
+//blockExtractOption 区块提取选项，主扫描与范围重扫的确认追踪和失败记录不同
+type blockExtractOption struct {
+	trackConfirm bool   //是否追踪等待确认的交易
+	unscanSymbol string //提取失败时未扫记录的标识
 }
 
 //BatchExtractTransaction 批量提取交易单
 //bitcoin 1M的区块链可以容纳3000笔交易，批量多线程处理，速度更快
 func (bs *BlockScanner) BatchExtractTransaction(block *pb.InternalBlock) error {
+	return bs.batchExtractTransaction(block, blockExtractOption{trackConfirm: true, unscanSymbol: bs.wm.Symbol()})
+}
+
+//batchExtractTransaction 按提取选项批量提取交易单
+func (bs *BlockScanner) batchExtractTransaction(block *pb.InternalBlock, option blockExtractOption) error {
+
+	//执行失败的交易不在区块的交易列表中，查询交易内容后按失败状态提取
+	txs := append(append([]*pb.Transaction{}, block.Transactions...), bs.blockFailedTxs(block)...)
+
+	bs.extractMu.Lock()
+	defer bs.extractMu.Unlock()
 
 	var (
 		quit       = make(chan struct{})
 		done       = 0 //完成标记
 		failed     = 0
-		shouldDone = len(block.Transactions) //需要完成的总数
+		shouldDone = len(txs) //需要完成的总数
 	)
 
-	if len(block.Transactions) == 0 {
+	if len(txs) == 0 {
 		return nil
 	}
 
@@ -344,15 +423,32 @@ func (bs *BlockScanner) BatchExtractTransaction(block *pb.InternalBlock) error {
 
 			if gets.Success {
 
-				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
+				notifyErr := bs.newExtractDataNotify(height, gets.extractData, option.unscanSymbol)
 				if notifyErr != nil {
 					failed++ //标记保存失败数
 					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
 				}
 
+				//通知代币交易
+				for key, list := range gets.extractTokenData {
+					for _, data := range list {
+						tokenData := map[string]*openwallet.TxExtractData{key: data}
+						notifyErr = bs.newExtractDataNotify(height, tokenData, option.unscanSymbol)
+						if notifyErr != nil {
+							failed++ //标记保存失败数
+							bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
+						}
+					}
+				}
+
+				//记录等待确认的交易
+				if option.trackConfirm {
+					bs.trackConfirmingTxs(height, gets)
+				}
+
 			} else {
 				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "", bs.wm.Symbol())
+				unscanRecord := openwallet.NewUnscanRecord(height, "", "", option.unscanSymbol)
 				bs.SaveUnscanRecord(unscanRecord)
 				bs.wm.Log.Std.Info("block height: %d extract failed.", height)
 				failed++ //标记保存失败数
@@ -367,18 +463,18 @@ func (bs *BlockScanner) BatchExtractTransaction(block *pb.InternalBlock) error {
 	}
 
 	//提取工作
-	extractWork := func(eblockHeight uint64, eBlockHash string, mTxs []*pb.Transaction, eProducer chan ExtractResult) {
+	extractWork := func(eBlock *pb.InternalBlock, mTxs []*pb.Transaction, eProducer chan ExtractResult) {
 		for _, tx := range mTxs {
 			bs.extractingCH <- struct{}{}
 			//shouldDone++
-			go func(mBlockHeight uint64, mTx *pb.Transaction, end chan struct{}, mProducer chan<- ExtractResult) {
+			go func(mBlock *pb.InternalBlock, mTx *pb.Transaction, end chan struct{}, mProducer chan<- ExtractResult) {
 
 				//导出提出的交易
-				mProducer <- bs.ExtractTransaction(mBlockHeight, eBlockHash, mTx, bs.ScanTargetFuncV2)
+				mProducer <- bs.ExtractTransaction(mBlock, mTx, bs.ScanTargetFuncV2)
 				//释放
 				<-end
 
-			}(eblockHeight, tx, bs.extractingCH, eProducer)
+			}(eBlock, tx, bs.extractingCH, eProducer)
 		}
 	}
 
@@ -388,7 +484,7 @@ func (bs *BlockScanner) BatchExtractTransaction(block *pb.InternalBlock) error {
 	go saveWork(uint64(block.Height), worker)
 
 	//独立线程运行生产
-	go extractWork(uint64(block.Height), hex.EncodeToString(block.Blockid), block.Transactions, producer)
+	go extractWork(block, txs, producer)
 
 	//以下使用生产消费模式
 	bs.extractRuntime(producer, worker, quit)
@@ -439,61 +535,92 @@ func (bs *BlockScanner) extractRuntime(producer chan ExtractResult, worker chan
 }
 
 //ExtractTransaction 提取交易单
-func (bs *BlockScanner) ExtractTransaction(blockHeight uint64, blockHash string, tx *pb.Transaction, scanAddressFunc openwallet.BlockScanTargetFuncV2) ExtractResult {
+func (bs *BlockScanner) ExtractTransaction(block *pb.InternalBlock, tx *pb.Transaction, scanAddressFunc openwallet.BlockScanTargetFuncV2) ExtractResult {
 
 	var (
-		result = ExtractResult{
+		blockHeight = uint64(block.GetHeight())
+		result      = ExtractResult{
 			BlockHeight:         blockHeight,
 			TxID:                hex.EncodeToString(tx.Txid),
 			extractData:         make(map[string]*openwallet.TxExtractData),
+			extractTokenData:    make(map[string][]*openwallet.TxExtractData),
 			extractContractData: make(map[string]*openwallet.SmartContractReceipt),
 		}
 	)
 
 	//提取主币交易单
-	bs.extractTransaction(blockHeight, tx, &result, scanAddressFunc)
+	bs.extractTransaction(block, tx, &result, scanAddressFunc)
 	//提取代币交易单
-	bs.extractSmartContractTransaction(blockHeight, tx, &result, scanAddressFunc)
+	bs.extractSmartContractTransaction(block, tx, &result, scanAddressFunc)
 	return result
 
 }
 
 //ExtractTransactionData 提取交易单
-func (bs *BlockScanner) extractTransaction(blockHeight uint64, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
+func (bs *BlockScanner) extractTransaction(block *pb.InternalBlock, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
 
 	var (
-		success = true
+		success        = true
+		blockHeight    = uint64(block.GetHeight())
+		isCoinbase     = isCoinbaseTransaction(trx)
+		status, reason = txExecutionStatus(block, trx)
 	)
 
-	txType := uint64(0)
+	txType := uint64(TxTypeTransfer)
 	txAction := ""
 
+	//coinbase及矿工奖励交易
+	if isCoinbase {
+		txType = TxTypeCoinbase
+		txAction = coinbaseTxAction(block)
+	}
+
+	//TDPOS提名、投票及撤销
+	staking, isStaking := parseStakingDesc(trx.Desc)
+	if isStaking {
+		txAction = staking.Module + "." + staking.Method
+		bs.extractStakingCandidates(staking, result, scanAddressFunc)
+	}
+
 	//提取出账部分记录
-	from, totalSpent := bs.extractTxInput(blockHeight, trx, result, scanAddressFunc)
+	from, _ := bs.extractTxInput(block, trx, result, scanAddressFunc)
 	//bs.wm.Log.Debug("from:", from, "totalSpent:", totalSpent)
 
 	//提取入账部分记录
-	to, totalReceived := bs.extractTxOutput(blockHeight, trx, result, scanAddressFunc)
+	to, _ := bs.extractTxOutput(block, trx, result, scanAddressFunc)
 	//bs.wm.Log.Debug("to:", to, "totalReceived:", totalReceived)
 
+	//手续费是转给$地址的输出，合约的gas也包含在内
+	fees := bs.transactionFees(trx)
+
 	for _, extractData := range result.extractData {
 		tx := &openwallet.Transaction{
 			From: from,
 			To:   to,
-			Fees: totalSpent.Sub(totalReceived).StringFixed(8),
+			Fees: fees.String(),
 			Coin: openwallet.Coin{
 				Symbol:     bs.wm.Symbol(),
 				IsContract: false,
 			},
-			BlockHash:   hex.EncodeToString(trx.Blockid),
+			BlockHash:   hex.EncodeToString(block.GetBlockid()),
 			BlockHeight: blockHeight,
 			TxID:        hex.EncodeToString(trx.Txid),
 			Decimal:     8,
-			ConfirmTime: trx.Timestamp,
-			Status:      openwallet.TxStatusSuccess,
+			ConfirmTime: blockConfirmTime(block, trx),
+			Status:      status,
+			Reason:      reason,
 			TxType:      txType,
 			TxAction:    txAction,
 		}
+		//记录出块者，便于区分奖励与转账
+		if isCoinbase {
+			tx.SetExtParam("proposer", string(block.GetProposer()))
+		}
+		//记录TDPOS操作的参数
+		if isStaking {
+			stakingArgs, _ := json.Marshal(staking.Args)
+			tx.SetExtParam("stakingArgs", string(stakingArgs))
+		}
 		wxID := openwallet.GenTransactionWxID(tx)
 		tx.WxID = wxID
 		extractData.Transaction = tx
@@ -505,8 +632,113 @@ func (bs *BlockScanner) extractTransaction(blockHeight uint64, trx *pb.Transacti
 
 }
 
+//extractStakingCandidates 被提名或投票的候选人为关注地址时，也提取该交易
+func (bs *BlockScanner) extractStakingCandidates(staking *StakingDesc, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
+	for _, candidate := range staking.Candidates() {
+		targetResult := scanAddressFunc(openwallet.ScanTargetParam{
+			ScanTarget:     candidate,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+		if !targetResult.Exist {
+			continue
+		}
+		if result.extractData[targetResult.SourceKey] == nil {
+			result.extractData[targetResult.SourceKey] = openwallet.NewBlockExtractData()
+		}
+	}
+}
+
+//transactionFees 计算交易手续费，即输出到$地址的总额，coinbase交易没有手续费
+func (bs *BlockScanner) transactionFees(trx *pb.Transaction) decimal.Decimal {
+
+	fees := decimal.Zero
+
+	if isCoinbaseTransaction(trx) {
+		return fees
+	}
+
+	for _, output := range trx.TxOutputs {
+		if string(output.ToAddr) == FeeAddress {
+			fees = fees.Add(common.BytesToDecimals(output.Amount, bs.wm.Decimal()))
+		}
+	}
+
+	return fees
+}
+
+//blockFailedTxs 查询区块记录的执行失败交易，节点已删除的交易查询不到内容，不再提取
+func (bs *BlockScanner) blockFailedTxs(block *pb.InternalBlock) []*pb.Transaction {
+
+	txs := make([]*pb.Transaction, 0)
+
+	for _, txid := range failedTxids(block) {
+		tx, err := bs.wm.RPC.QueryTx(txid)
+		if err != nil || tx.GetTx() == nil {
+			bs.wm.Log.Std.Info("block height: %d failed transaction: %s can not be queried, err: %v", block.GetHeight(), txid, err)
+			continue
+		}
+		txs = append(txs, tx.GetTx())
+	}
+
+	return txs
+}
+
+//failedTxids 区块记录的执行失败交易，节点打包时已将其排除在交易列表之外，按txid排序
+func failedTxids(block *pb.InternalBlock) []string {
+
+	included := make(map[string]bool)
+	for _, tx := range block.GetTransactions() {
+		included[hex.EncodeToString(tx.Txid)] = true
+	}
+
+	txids := make([]string, 0, len(block.GetFailedTxs()))
+	for txid := range block.GetFailedTxs() {
+		if !included[txid] {
+			txids = append(txids, txid)
+		}
+	}
+	sort.Strings(txids)
+
+	return txids
+}
+
+//txExecutionStatus 交易的执行状态及失败原因，区块记录的失败交易视为执行失败，原因为合约返回的错误
+func txExecutionStatus(block *pb.InternalBlock, trx *pb.Transaction) (string, string) {
+	if reason, failed := block.GetFailedTxs()[hex.EncodeToString(trx.Txid)]; failed {
+		if len(reason) == 0 {
+			reason = "transaction execute failed"
+		}
+		return openwallet.TxStatusFail, reason
+	}
+	return openwallet.TxStatusSuccess, ""
+}
+
+//blockConfirmTime 交易确认时间，使用区块时间戳，单位秒
+func blockConfirmTime(block *pb.InternalBlock, trx *pb.Transaction) int64 {
+	timestamp := block.GetTimestamp()
+	if timestamp == 0 {
+		timestamp = trx.Timestamp
+	}
+	//链上时间戳为纳秒
+	return timestamp / int64(time.Second)
+}
+
+//isCoinbaseTransaction 是否coinbase或矿工奖励交易
+func isCoinbaseTransaction(trx *pb.Transaction) bool {
+	return trx.Coinbase && len(trx.TxInputs) == 0
+}
+
+//coinbaseTxAction coinbase交易的动作，创世块的coinbase为初始分配，之后区块的coinbase都是出块节点生成的奖励交易，
+//奖励交易的Desc由节点填写（如GenerateAwardTx的"1"），不能用于区分
+func coinbaseTxAction(block *pb.InternalBlock) string {
+	if block.GetHeight() == 0 {
+		return TxActionCoinbase
+	}
+	return TxActionAward
+}
+
 //ExtractTxInput 提取交易单输入部分
-func (bs *BlockScanner) extractTxInput(blockHeight uint64, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) ([]string, decimal.Decimal) {
+func (bs *BlockScanner) extractTxInput(block *pb.InternalBlock, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) ([]string, decimal.Decimal) {
 
 	//vin := trx.Get("vin")
 
@@ -515,7 +747,7 @@ func (bs *BlockScanner) extractTxInput(blockHeight uint64, trx *pb.Transaction,
 		totalAmount = decimal.Zero
 	)
 
-	txType := uint64(0)
+	txType := uint64(TxTypeTransfer)
 
 	createAt := time.Now().Unix()
 	for i, output := range trx.TxInputs {
@@ -553,8 +785,8 @@ func (bs *BlockScanner) extractTxInput(blockHeight uint64, trx *pb.Transaction,
 			//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
 			input.CreateAt = createAt
 			//在哪个区块高度时消费
-			input.BlockHeight = blockHeight
-			input.BlockHash = hex.EncodeToString(trx.Blockid)
+			input.BlockHeight = uint64(block.GetHeight())
+			input.BlockHash = hex.EncodeToString(block.GetBlockid())
 			input.TxType = txType
 
 			//transactions = append(transactions, &transaction)
@@ -577,14 +809,17 @@ func (bs *BlockScanner) extractTxInput(blockHeight uint64, trx *pb.Transaction,
 }
 
 //ExtractTxInput 提取交易单输入部分
-func (bs *BlockScanner) extractTxOutput(blockHeight uint64, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) ([]string, decimal.Decimal) {
+func (bs *BlockScanner) extractTxOutput(block *pb.InternalBlock, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) ([]string, decimal.Decimal) {
 
 	var (
 		to          = make([]string, 0)
 		totalAmount = decimal.Zero
 	)
 
-	txType := uint64(0)
+	txType := uint64(TxTypeTransfer)
+	if isCoinbaseTransaction(trx) {
+		txType = TxTypeCoinbase
+	}
 
 	vout := trx.TxOutputs
 	txid := hex.EncodeToString(trx.Txid)
@@ -594,6 +829,12 @@ func (bs *BlockScanner) extractTxOutput(blockHeight uint64, trx *pb.Transaction,
 
 		amount := common.BytesToDecimals(output.Amount, bs.wm.Decimal())
 		addr := string(output.ToAddr)
+
+		//手续费输出不是接收者
+		if addr == FeeAddress {
+			continue
+		}
+
 		targetResult := scanAddressFunc(openwallet.ScanTargetParam{
 			ScanTarget:     addr,
 			Symbol:         bs.wm.Symbol(),
@@ -619,8 +860,8 @@ func (bs *BlockScanner) extractTxOutput(blockHeight uint64, trx *pb.Transaction,
 			//outPut.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("output_%s_%d_%s", txid, n, addr))))
 
 			outPut.CreateAt = createAt
-			outPut.BlockHeight = blockHeight
-			outPut.BlockHash = hex.EncodeToString(trx.Txid)
+			outPut.BlockHeight = uint64(block.GetHeight())
+			outPut.BlockHash = hex.EncodeToString(block.GetBlockid())
 			outPut.TxType = txType
 
 			//transactions = append(transactions, &transaction)
@@ -643,16 +884,30 @@ func (bs *BlockScanner) extractTxOutput(blockHeight uint64, trx *pb.Transaction,
 	return to, totalAmount
 }
 
-
 // extractSmartContractTransaction 提取智能合约交易单
-func (bs *BlockScanner) extractSmartContractTransaction(blockHeight uint64, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
+//一个交易可包含多个合约调用，每个合约生成独立的回执，同一合约的多次调用合并到一个回执
+func (bs *BlockScanner) extractSmartContractTransaction(block *pb.InternalBlock, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
 
-	for _, contractRequest := range trx.ContractRequests {
+	var (
+		blockHeight    = uint64(block.GetHeight())
+		confirmTime    = blockConfirmTime(block, trx)
+		status, reason = txExecutionStatus(block, trx)
+		invokes        = make([]*contractInvokeReceipt, 0)
+		invokeIndex    = make(map[string]*contractInvokeReceipt)
+	)
+
+	for i, contractRequest := range trx.ContractRequests {
 
 		moduleName := contractRequest.ModuleName
 		contractName := contractRequest.ContractName
 		contractAddress := moduleName + ":" + contractName
 
+		//同一合约的多次调用
+		if invoke, exist := invokeIndex[contractAddress]; exist {
+			invoke.addRequest(i, contractRequest)
+			continue
+		}
+
 		//查找合约是否存在
 		targetResult := scanAddressFunc(openwallet.ScanTargetParam{
 			ScanTarget:     contractAddress,
@@ -670,10 +925,27 @@ func (bs *BlockScanner) extractSmartContractTransaction(blockHeight uint64, trx
 			return
 		}
 
-		//没有纪录ABI，不处理提取
-		if len(contract.GetABI()) == 0 {
-			return
+		invoke := &contractInvokeReceipt{
+			sourceKey: targetResult.SourceKey,
+			contract:  contract,
 		}
+		invoke.addRequest(i, contractRequest)
+		invokes = append(invokes, invoke)
+		invokeIndex[contractAddress] = invoke
+	}
+
+	//被调用合约的名称，其余合约的日志归属第一个回执
+	callContracts := make(map[string]bool)
+	for _, contractRequest := range trx.ContractRequests {
+		callContracts[contractRequest.ContractName] = true
+	}
+	consumedLogs := make(map[int]bool)
+	consumedStates := make(map[int]bool)
+
+	for n, invoke := range invokes {
+
+		contract := invoke.contract
+		moduleName, contractName := splitContractAddress(contract.Address)
 
 		coin := openwallet.Coin{
 			Symbol:     bs.wm.Symbol(),
@@ -682,44 +954,36 @@ func (bs *BlockScanner) extractSmartContractTransaction(blockHeight uint64, trx
 			Contract:   *contract,
 		}
 
-		createAt := time.Now().Unix()
-
-		//迭代每个日志，提取时间日志
-		events := make([]*openwallet.SmartContractEvent, 0)
-		for _, outPutExt := range trx.GetTxOutputsExt() {
-
-			if string(outPutExt.Key) == EVENT_KEY {
-
-				bucket := outPutExt.Bucket
-				bucketContractAddress := moduleName + ":" + bucket
-
-				logTargetResult := scanAddressFunc(openwallet.ScanTargetParam{
-					ScanTarget:     bucketContractAddress,
-					Symbol:         bs.wm.Symbol(),
-					ScanTargetType: openwallet.ScanTargetTypeContractAddress})
-				if !logTargetResult.Exist {
-					continue
-				}
-
-				logContract, logOk := logTargetResult.TargetInfo.(*openwallet.SmartContract)
-				if !logOk {
-					bs.wm.Log.Errorf("log target result can not convert to openwallet.SmartContract")
-					result.Success = false
-					return
-				}
+		//迭代每个日志，提取合约事件，其余被调用合约的日志不归属当前回执
+		events, err := bs.extractKeyEvents(trx, moduleName, func(bucket string) bool {
+			return bucket == contractName || !callContracts[bucket]
+		}, consumedLogs, scanAddressFunc)
+		if err != nil {
+			bs.wm.Log.Errorf("extract contract events failed, err: %v", err)
+			result.Success = false
+			return
+		}
 
-				eventJSON := gjson.ParseBytes(outPutExt.Value)
-				for _, e := range eventJSON.Array() {
-					e := &openwallet.SmartContractEvent{
-						Contract: logContract,
-						Event:    e.Get("event").String(),
-						Value:    e.Get("value").Raw,
-					}
+		//合约原生事件按abi事件定义解码
+		nativeEvents, err := bs.extractNativeEvents(trx, contract)
+		if err != nil {
+			bs.wm.Log.Errorf("extract native events failed, err: %v", err)
+			result.Success = false
+			return
+		}
+		events = append(events, nativeEvents...)
 
-					events = append(events, e)
-				}
+		//合约存储的变更
+		if bs.wm.Config.EnableStateDiff {
+			invoke.stateChanges = bs.extractStateChanges(trx, moduleName, func(bucket string) bool {
+				return bucket == contractName || !callContracts[bucket]
+			}, consumedStates)
+		}
 
-			}
+		//交易手续费只记录在第一个回执，其余回执为0，避免多合约调用的手续费重复统计
+		fees := "0"
+		if n == 0 {
+			fees = bs.transactionFees(trx).String()
 		}
 
 		scReceipt := &openwallet.SmartContractReceipt{
@@ -727,152 +991,249 @@ func (bs *BlockScanner) extractSmartContractTransaction(blockHeight uint64, trx
 			TxID:        hex.EncodeToString(trx.Txid),
 			From:        trx.Initiator,
 			To:          contractName,
-			Fees:        "0",
+			Fees:        fees,
 			Value:       "0",
-			RawReceipt:  "",
+			RawReceipt:  invoke.rawReceipt(),
 			Events:      events,
-			BlockHash:   hex.EncodeToString(trx.Blockid),
+			BlockHash:   hex.EncodeToString(block.GetBlockid()),
 			BlockHeight: blockHeight,
-			ConfirmTime: createAt,
-			Status:      "1",
-			Reason:      "",
+			ConfirmTime: confirmTime,
+			Status:      status,
+			Reason:      reason,
 		}
 
 		scReceipt.GenWxID()
 
-		result.extractContractData[targetResult.SourceKey] = scReceipt
+		result.extractContractData[invoke.sourceKey] = scReceipt
+
+		//提取代币转账
+		transfers, transferErr := parseTokenTransfers(trx, invoke.requests, contract, events)
+		if transferErr != nil {
+			bs.wm.Log.Errorf("tx: %s contract: %s %v", hex.EncodeToString(trx.Txid), contract.Address, transferErr)
+		}
+		if len(transfers) > 0 {
+			bs.extractTokenTransfer(block, trx, coin, transfers, result, scanAddressFunc)
+		}
+
+	}
+}
+
+//contractInvokeReceipt 交易中同一合约的调用
+type contractInvokeReceipt struct {
+	sourceKey    string
+	contract     *openwallet.SmartContract
+	requests     []*pb.InvokeRequest
+	calls        []contractInvokeRecord
+	stateChanges []*ContractStateChange
+}
+
+//contractRawReceipt 合约回执的RawReceipt
+type contractRawReceipt struct {
+	Calls        []contractInvokeRecord `json:"calls"`                  //合约调用记录
+	StateChanges []*ContractStateChange `json:"stateChanges,omitempty"` //合约存储的变更
+}
+
+//contractInvokeRecord 合约调用记录，保存在回执的RawReceipt
+type contractInvokeRecord struct {
+	Index  int    `json:"index"`  //在交易中的调用序号
+	Method string `json:"method"` //调用方法
+}
+
+//addRequest 添加合约调用
+func (r *contractInvokeReceipt) addRequest(index int, req *pb.InvokeRequest) {
+	r.requests = append(r.requests, req)
+	r.calls = append(r.calls, contractInvokeRecord{Index: index, Method: req.MethodName})
+}
+
+//rawReceipt 合约调用记录和存储变更的json
+func (r *contractInvokeReceipt) rawReceipt() string {
+	raw, err := json.Marshal(contractRawReceipt{Calls: r.calls, StateChanges: r.stateChanges})
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+//tokenTransfer 代币转账记录
+type tokenTransfer struct {
+	From   string
+	To     string
+	Amount decimal.Decimal
+}
+
+//parseTokenTransfers 解析代币转账，优先使用合约的transfer事件，没有事件则解析已知的转账方法调用
+//evm合约的调用参数为abi编码的input，只能通过事件解析
+//数量无效的转账不提取，通过error返回
+func parseTokenTransfers(trx *pb.Transaction, contractRequests []*pb.InvokeRequest, contract *openwallet.SmartContract, events []*openwallet.SmartContractEvent) ([]*tokenTransfer, error) {
+
+	var (
+		transfers = make([]*tokenTransfer, 0)
+		invalid   = make([]string, 0)
+	)
+
+	//添加转账，数量无效时记录并跳过
+	addTransfer := func(from, to, amount string) {
+		value, err := tokenAmount(amount, contract.Decimals)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			return
+		}
+		transfers = append(transfers, &tokenTransfer{From: from, To: to, Amount: value})
+	}
+
+	for _, e := range events {
+		if e.Contract == nil || e.Contract.Address != contract.Address {
+			continue
+		}
+		if !strings.EqualFold(e.Event, tokenTransferEvent) {
+			continue
+		}
+		value := gjson.Parse(e.Value)
+		amount := value.Get("amount")
+		if !amount.Exists() {
+			amount = value.Get("value")
+		}
+		addTransfer(value.Get("from").String(), value.Get("to").String(), amount.String())
+	}
+
+	if len(transfers) > 0 || len(invalid) > 0 {
+		return transfers, invalidTokenTransfers(invalid)
+	}
+
+	for _, contractRequest := range contractRequests {
+		if contractRequest.ModuleName == MODULE_EVM {
+			continue
+		}
+		args := contractRequest.GetArgs()
+		switch contractRequest.MethodName {
+		case tokenTransferMethod:
+			addTransfer(trx.Initiator, string(args["to"]), string(args["amount"]))
+		case tokenTransferFromMethod:
+			addTransfer(string(args["from"]), string(args["to"]), string(args["amount"]))
+		}
+	}
+
+	return transfers, invalidTokenTransfers(invalid)
+}
+
+//invalidTokenTransfers 无效转账的错误
+func invalidTokenTransfers(invalid []string) error {
+	if len(invalid) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid token transfers: %s", strings.Join(invalid, "; "))
+}
 
+//tokenAmount 代币数量转为带精度的数值，非数字或负数返回错误
+func tokenAmount(amount string, decimals uint64) (decimal.Decimal, error) {
+	d, err := decimal.NewFromString(amount)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("amount: %s is not a number", amount)
+	}
+	if d.IsNegative() {
+		return decimal.Zero, fmt.Errorf("amount: %s is negative", amount)
 	}
+	return d.Shift(-int32(decimals)), nil
 }
 
 //extractTokenTransfer 提取交易单中的代币交易
-//func (bs *BlockScanner) extractTokenTransfer(blockHeight uint64, trx *pb.Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
-//
-//	var (
-//		success = true
-//	)
-//
-//	if trx == nil {
-//		//记录哪个区块哪个交易单没有完成扫描
-//		success = false
-//	} else {
-//
-//		if trx.Isqrc20Transfer {
-//			createAt := time.Now().Unix()
-//			//QTUM交易单目前只允许包含一个代币交易
-//			for _, tokenReceipt := range trx.TokenReceipts {
-//
-//				contractId := openwallet.GenContractID(bs.wm.Symbol(), tokenReceipt.ContractAddress)
-//
-//				coin := openwallet.Coin{
-//					Symbol:     bs.wm.Symbol(),
-//					IsContract: true,
-//					ContractID: contractId,
-//					Contract: openwallet.SmartContract{
-//						ContractID: contractId,
-//						Address:    tokenReceipt.ContractAddress,
-//						Protocol:   "qrc20",
-//						Symbol:     bs.wm.Symbol(),
-//					},
-//				}
-//
-//				targetResult := scanAddressFunc(openwallet.ScanTargetParam{
-//					ScanTarget:     tokenReceipt.From,
-//					Symbol:         bs.wm.Symbol(),
-//					ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
-//				if targetResult.Exist {
-//					input := openwallet.TxInput{}
-//					input.TxID = trx.TxID
-//					input.Address = tokenReceipt.From
-//					//transaction.AccountID = a.AccountID
-//					input.Amount = tokenReceipt.Amount
-//					input.Coin = coin
-//					input.Index = 0
-//					input.Sid = openwallet.GenTxInputSID(tokenReceipt.TxHash, bs.wm.Symbol(), contractId, 0)
-//					//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
-//					input.CreateAt = createAt
-//					//在哪个区块高度时消费
-//					input.BlockHeight = tokenReceipt.BlockHeight
-//					input.BlockHash = tokenReceipt.BlockHash
-//
-//					//transactions = append(transactions, &transaction)
-//
-//					ed := result.extractContractData[targetResult.SourceKey]
-//					if ed == nil {
-//						ed = openwallet.NewBlockExtractData()
-//						result.extractContractData[targetResult.SourceKey] = ed
-//					}
-//
-//					ed.TxInputs = append(ed.TxInputs, &input)
-//
-//				}
-//
-//				targetResult2 := scanAddressFunc(openwallet.ScanTargetParam{
-//					ScanTarget:     tokenReceipt.To,
-//					Symbol:         bs.wm.Symbol(),
-//					ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
-//				if targetResult2.Exist {
-//					output := openwallet.TxOutPut{}
-//					output.TxID = trx.TxID
-//					output.Address = tokenReceipt.To
-//					//transaction.AccountID = a.AccountID
-//					output.Amount = tokenReceipt.Amount
-//
-//					output.Coin = coin
-//					output.Index = 0
-//					output.Sid = openwallet.GenTxOutPutSID(tokenReceipt.TxHash, bs.wm.Symbol(), contractId, 0)
-//					//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
-//					output.CreateAt = createAt
-//					//在哪个区块高度时消费
-//					output.BlockHeight = tokenReceipt.BlockHeight
-//					output.BlockHash = tokenReceipt.BlockHash
-//
-//					//transactions = append(transactions, &transaction)
-//
-//					ed := result.extractContractData[targetResult2.SourceKey]
-//					if ed == nil {
-//						ed = openwallet.NewBlockExtractData()
-//						result.extractContractData[targetResult2.SourceKey] = ed
-//					}
-//
-//					ed.TxOutputs = append(ed.TxOutputs, &output)
-//				}
-//
-//				blocktime := trx.Blocktime
-//
-//				for _, extractData := range result.extractContractData {
-//					tx := &openwallet.Transaction{
-//						From:        []string{tokenReceipt.From + ":" + tokenReceipt.Amount},
-//						To:          []string{tokenReceipt.To + ":" + tokenReceipt.Amount},
-//						Fees:        "0",
-//						Coin:        coin,
-//						BlockHash:   tokenReceipt.BlockHash,
-//						BlockHeight: tokenReceipt.BlockHeight,
-//						TxID:        tokenReceipt.TxHash,
-//						Decimal:     0,
-//						ConfirmTime: blocktime,
-//						Status:      openwallet.TxStatusSuccess,
-//						TxType:      0,
-//					}
-//					wxID := openwallet.GenTransactionWxID(tx)
-//					tx.WxID = wxID
-//					extractData.Transaction = tx
-//
-//					//bs.wm.Log.Debug("Transaction:", extractData.Transaction)
-//				}
-//			}
-//		}
-//
-//		success = true
-//
-//	}
-//
-//	result.Success = success
-//
-//}
-
-//newExtractDataNotify 发送通知
-func (bs *BlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData) error {
+func (bs *BlockScanner) extractTokenTransfer(block *pb.InternalBlock, trx *pb.Transaction, coin openwallet.Coin, transfers []*tokenTransfer, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
+
+	var (
+		blockHeight    = uint64(block.GetHeight())
+		status, reason = txExecutionStatus(block, trx)
+		txid           = hex.EncodeToString(trx.Txid)
+		blockHash      = hex.EncodeToString(block.GetBlockid())
+		createAt       = time.Now().Unix()
+		from           = make([]string, 0)
+		to             = make([]string, 0)
+		tokenData      = make(map[string]*openwallet.TxExtractData)
+		totalAmount    = decimal.Zero
+	)
+
+	for i, transfer := range transfers {
+
+		targetResult := scanAddressFunc(openwallet.ScanTargetParam{
+			ScanTarget:     transfer.From,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+		if targetResult.Exist {
+			input := openwallet.TxInput{}
+			input.TxID = txid
+			input.Address = transfer.From
+			input.Amount = transfer.Amount.String()
+			input.Coin = coin
+			input.Index = uint64(i)
+			input.Sid = openwallet.GenTxInputSID(txid, bs.wm.Symbol(), coin.ContractID, uint64(i))
+			input.CreateAt = createAt
+			input.BlockHeight = blockHeight
+			input.BlockHash = blockHash
+
+			ed := tokenData[targetResult.SourceKey]
+			if ed == nil {
+				ed = openwallet.NewBlockExtractData()
+				tokenData[targetResult.SourceKey] = ed
+			}
+
+			ed.TxInputs = append(ed.TxInputs, &input)
+		}
+
+		targetResult2 := scanAddressFunc(openwallet.ScanTargetParam{
+			ScanTarget:     transfer.To,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+		if targetResult2.Exist {
+			output := openwallet.TxOutPut{}
+			output.TxID = txid
+			output.Address = transfer.To
+			output.Amount = transfer.Amount.String()
+			output.Coin = coin
+			output.Index = uint64(i)
+			output.Sid = openwallet.GenTxOutPutSID(txid, bs.wm.Symbol(), coin.ContractID, uint64(i))
+			output.CreateAt = createAt
+			output.BlockHeight = blockHeight
+			output.BlockHash = blockHash
+
+			ed := tokenData[targetResult2.SourceKey]
+			if ed == nil {
+				ed = openwallet.NewBlockExtractData()
+				tokenData[targetResult2.SourceKey] = ed
+			}
+
+			ed.TxOutputs = append(ed.TxOutputs, &output)
+		}
+
+		from = append(from, transfer.From+":"+transfer.Amount.String())
+		to = append(to, transfer.To+":"+transfer.Amount.String())
+		totalAmount = totalAmount.Add(transfer.Amount)
+	}
+
+	for sourceKey, extractData := range tokenData {
+		tx := &openwallet.Transaction{
+			From:        from,
+			To:          to,
+			Amount:      totalAmount.String(),
+			Fees:        "0",
+			Coin:        coin,
+			BlockHash:   blockHash,
+			BlockHeight: blockHeight,
+			TxID:        txid,
+			Decimal:     int32(coin.Contract.Decimals),
+			ConfirmTime: blockConfirmTime(block, trx),
+			Status:      status,
+			Reason:      reason,
+			TxType:      TxTypeTransfer,
+		}
+		wxID := openwallet.GenTransactionWxID(tx)
+		tx.WxID = wxID
+		extractData.Transaction = tx
+
+		result.extractTokenData[sourceKey] = append(result.extractTokenData[sourceKey], extractData)
+	}
+}
+
+//newExtractDataNotify 发送通知，通知失败时按unscanSymbol记录未扫区块
+func (bs *BlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData, unscanSymbol string) error {
 
 	for o, _ := range bs.Observers {
 		for key, data := range extractData {
@@ -880,7 +1241,7 @@ func (bs *BlockScanner) newExtractDataNotify(height uint64, extractData map[stri
 			if err != nil {
 				bs.wm.Log.Error("BlockExtractDataNotify unexpected error:", err)
 				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractData Notify failed.", bs.wm.Symbol())
+				unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractData Notify failed.", unscanSymbol)
 				err = bs.SaveUnscanRecord(unscanRecord)
 				if err != nil {
 					bs.wm.Log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err.Error())
@@ -893,7 +1254,8 @@ func (bs *BlockScanner) newExtractDataNotify(height uint64, extractData map[stri
 	return nil
 }
 
-func (bs *BlockScanner) ExtractTransactionData(txid string, scanTargetFunc openwallet.BlockScanTargetFunc) (map[string][]*openwallet.TxExtractData, error) {
+//extractTransactionData 提取本链的交易单数据
+func (bs *BlockScanner) extractTransactionData(txid string, scanTargetFunc openwallet.BlockScanTargetFunc) (map[string][]*openwallet.TxExtractData, error) {
 
 	scanTargetFuncV2 := func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
 		sourceKey, ok := scanTargetFunc(openwallet.ScanTarget{
@@ -901,92 +1263,186 @@ func (bs *BlockScanner) ExtractTransactionData(txid string, scanTargetFunc openw
 			Symbol:           bs.wm.Symbol(),
 			BalanceModelType: bs.wm.BalanceModelType(),
 		})
-		return openwallet.ScanTargetResult{
+		result := openwallet.ScanTargetResult{
 			SourceKey: sourceKey,
 			Exist:     ok,
 		}
+		//V1接口没有合约信息，合约以sourceKey为ContractID，没有ABI时事件不解码
+		if ok && target.ScanTargetType == openwallet.ScanTargetTypeContractAddress {
+			result.TargetInfo = &openwallet.SmartContract{
+				ContractID: sourceKey,
+				Address:    target.ScanTarget,
+				Symbol:     bs.wm.Symbol(),
+			}
+		}
+		return result
 	}
 
-	tx, err := bs.wm.RPC.QueryTx(txid)
-	if err != nil {
-		return nil, err
-	}
-
-	block, err := bs.wm.RPC.GetBlock(hex.EncodeToString(tx.Tx.Blockid))
+	tx, block, err := bs.queryTransactionBlock(txid)
 	if err != nil {
 		return nil, err
 	}
 
-	result := bs.ExtractTransaction(uint64(block.Height), hex.EncodeToString(tx.Tx.Blockid), tx.Tx, scanTargetFuncV2)
+	result := bs.ExtractTransaction(block, tx.Tx, scanTargetFuncV2)
 	if !result.Success {
 		return nil, fmt.Errorf("extract transaction failed")
 	}
 
-	extData := make(map[string][]*openwallet.TxExtractData)
-	for key, data := range result.extractData {
-		txs := extData[key]
-		if txs == nil {
-			txs = make([]*openwallet.TxExtractData, 0)
-		}
-		txs = append(txs, data)
-		extData[key] = txs
-	}
+	receipts := txStatusReceipts(tx, result.extractContractData)
 
-	//for key, data := range result.extractContractData {
-	//	txs := extData[key]
-	//	if txs == nil {
-	//		txs = make([]*openwallet.TxExtractData, 0)
-	//	}
-	//	txs = append(txs, data)
-	//	extData[key] = txs
-	//}
+	extData := txStatusExtractData(tx, result.txExtractDataMap())
+
+	//V1接口没有回执，合约回执转为交易提取数据
+	for key, receipt := range receipts {
+		extData[key] = append(extData[key], receiptExtractData(receipt))
+	}
 
 	return extData, nil
 }
 
+//extractTransactionAndReceiptData 提取本链的交易单及交易回执数据
+func (bs *BlockScanner) extractTransactionAndReceiptData(txid string, scanTargetFunc openwallet.BlockScanTargetFuncV2) (map[string][]*openwallet.TxExtractData, map[string]*openwallet.SmartContractReceipt, error) {
+
+	tx, block, err := bs.queryTransactionBlock(txid)
+	if err != nil {
+		bs.wm.Log.Errorf("get transaction by has failed, err: %v", err)
+		return nil, nil, err
+	}
+
+	result := bs.ExtractTransaction(block, tx.Tx, scanTargetFunc)
+	if !result.Success {
+		return nil, nil, fmt.Errorf("extract transaction failed")
+	}
 
-//ExtractTransactionAndReceiptData 提取交易单及交易回执数据
-//@required
-func (bs *BlockScanner) ExtractTransactionAndReceiptData(txid string, scanTargetFunc openwallet.BlockScanTargetFuncV2) (map[string][]*openwallet.TxExtractData, map[string]*openwallet.SmartContractReceipt, error) {
+	return txStatusExtractData(tx, result.txExtractDataMap()), txStatusReceipts(tx, result.extractContractData), nil
+}
+
+//queryTransactionBlock 查询交易及所在区块，未确认的交易没有区块，返回nil
+func (bs *BlockScanner) queryTransactionBlock(txid string) (*pb.TxStatus, *pb.InternalBlock, error) {
 
 	tx, err := bs.wm.RPC.QueryTx(txid)
 	if err != nil {
-		bs.wm.Log.Errorf("get transaction by has failed, err: %v", err)
 		return nil, nil, err
 	}
 
+	if tx.GetTx() == nil {
+		return nil, nil, fmt.Errorf("transaction: %s is not exist", txid)
+	}
+
+	//交易池中未确认的交易
+	if tx.GetStatus() == pb.TransactionStatus_UNCONFIRM || len(tx.GetTx().GetBlockid()) == 0 {
+		return tx, nil, nil
+	}
+
 	block, _ := bs.wm.RPC.GetBlock(hex.EncodeToString(tx.Tx.Blockid))
 	if block == nil {
 		return nil, nil, fmt.Errorf("get block failed")
 	}
-	result := bs.ExtractTransaction(uint64(block.Height), hex.EncodeToString(tx.Tx.Blockid), tx.Tx, scanTargetFunc)
-	if !result.Success {
-		return nil, nil, fmt.Errorf("extract transaction failed")
-	}
+
+	return tx, block, nil
+}
+
+//txExtractDataMap 合并主币及代币的交易提取数据
+func (result *ExtractResult) txExtractDataMap() map[string][]*openwallet.TxExtractData {
 
 	extData := make(map[string][]*openwallet.TxExtractData)
 	for key, data := range result.extractData {
-		txs := extData[key]
-		if txs == nil {
-			txs = make([]*openwallet.TxExtractData, 0)
+		extData[key] = append(extData[key], data)
+	}
+
+	for key, list := range result.extractTokenData {
+		extData[key] = append(extData[key], list...)
+	}
+
+	return extData
+}
+
+//txChainStatus 交易的链上状态，已确认的交易返回false，使用执行结果的状态
+func txChainStatus(tx *pb.TxStatus) (string, string, bool) {
+	switch tx.GetStatus() {
+	case pb.TransactionStatus_FURCATION:
+		//交易所在区块已被分叉回滚
+		return openwallet.TxStatusFail, "transaction is in furcation block", true
+	case pb.TransactionStatus_UNCONFIRM:
+		//交易池中未出块的交易
+		return TxStatusPending, "transaction is unconfirmed", true
+	}
+	return "", "", false
+}
+
+//txStatusReceipts 按交易的链上状态修正回执状态
+func txStatusReceipts(tx *pb.TxStatus, receipts map[string]*openwallet.SmartContractReceipt) map[string]*openwallet.SmartContractReceipt {
+
+	status, reason, ok := txChainStatus(tx)
+	if !ok {
+		return receipts
+	}
+
+	for _, receipt := range receipts {
+		receipt.Status = status
+		receipt.Reason = reason
+	}
+
+	return receipts
+}
+
+//txStatusExtractData 按交易的链上状态修正交易提取数据的状态
+func txStatusExtractData(tx *pb.TxStatus, extData map[string][]*openwallet.TxExtractData) map[string][]*openwallet.TxExtractData {
+
+	status, reason, ok := txChainStatus(tx)
+	if !ok {
+		return extData
+	}
+
+	for _, list := range extData {
+		for _, data := range list {
+			if data.Transaction == nil {
+				continue
+			}
+			data.Transaction.Status = status
+			data.Transaction.Reason = reason
+			data.Transaction.WxID = openwallet.GenTransactionWxID(data.Transaction)
 		}
-		txs = append(txs, data)
-		extData[key] = txs
 	}
 
-	//for key, data := range result.extractContractData {
-	//	txs := extData[key]
-	//	if txs == nil {
-	//		txs = make([]*openwallet.TxExtractData, 0)
-	//	}
-	//	txs = append(txs, data)
-	//	extData[key] = txs
-	//}
+	return extData
+}
+
+//receiptExtractData 合约回执转为交易提取数据，事件记录在扩展参数
+func receiptExtractData(receipt *openwallet.SmartContractReceipt) *openwallet.TxExtractData {
+
+	tx := &openwallet.Transaction{
+		From:        []string{receipt.From},
+		To:          []string{receipt.To},
+		Amount:      receipt.Value,
+		Fees:        receipt.Fees,
+		Coin:        receipt.Coin,
+		BlockHash:   receipt.BlockHash,
+		BlockHeight: receipt.BlockHeight,
+		TxID:        receipt.TxID,
+		Decimal:     int32(receipt.Coin.Contract.Decimals),
+		ConfirmTime: receipt.ConfirmTime,
+		Status:      receipt.Status,
+		Reason:      receipt.Reason,
+		TxType:      TxTypeContractCall,
+		TxAction:    TxActionContractCall,
+	}
+
+	if len(receipt.Events) > 0 {
+		events, _ := json.Marshal(receipt.Events)
+		tx.SetExtParam("events", string(events))
+	}
+	tx.SetExtParam("rawReceipt", receipt.RawReceipt)
+
+	tx.WxID = openwallet.GenTransactionWxID(tx)
+
+	extractData := openwallet.NewBlockExtractData()
+	extractData.Transaction = tx
 
-	return extData, result.extractContractData, nil
+	return extractData
 }
 
-//GetBalanceByAddress
+//GetBalanceByAddress 查询本链地址的余额，平行链的余额通过Chain获取对应链的扫描器查询
 func (bs *BlockScanner) GetBalanceByAddress(address ...string) ([]*openwallet.Balance, error) {
 
 	balanceArray := make([]*openwallet.Balance, 0)
@@ -0,0 +1,321 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/xuperchain/xuperchain/core/pb"
+)
+
+const (
+	confirmCheckpointSuffix = "_CONFIRM" //确认检查点的标识后缀，该高度及以下的交易已完成确认通知
+)
+
+//BlockConfirmNotificationObject 交易达到确认深度的通知，观测者可选实现
+//只有实现该接口的观测者才会收到确认通知，有这类观测者时才追踪等待确认的交易
+type BlockConfirmNotificationObject interface {
+
+	//BlockExtractDataConfirmNotify 交易达到确认深度通知
+	BlockExtractDataConfirmNotify(sourceKey string, data *openwallet.TxExtractData) error
+}
+
+//confirmingTx 等待确认的交易
+type confirmingTx struct {
+	height      uint64
+	extractData map[string]*openwallet.TxExtractData
+	failed      confirmNotifyFailures //通知失败待重试的观测者及数据，为空时通知所有观测者
+}
+
+//confirmNotifyFailures 每个观测者通知失败的数据
+type confirmNotifyFailures map[BlockConfirmNotificationObject]map[string]*openwallet.TxExtractData
+
+//confirmObservers 实现了确认通知的观测者
+func (bs *BlockScanner) confirmObservers() []BlockConfirmNotificationObject {
+	observers := make([]BlockConfirmNotificationObject, 0)
+	for o := range bs.Observers {
+		if co, ok := o.(BlockConfirmNotificationObject); ok {
+			observers = append(observers, co)
+		}
+	}
+	return observers
+}
+
+//isConfirmTracking 是否追踪等待确认的交易
+func (bs *BlockScanner) isConfirmTracking() bool {
+	if bs.wm.Config.ConfirmDepth == 0 && !bs.wm.Config.UseIrreversibleHeight {
+		return false
+	}
+	return len(bs.confirmObservers()) > 0
+}
+
+//trackConfirmingTxs 记录提取结果中等待确认的主币及代币交易
+func (bs *BlockScanner) trackConfirmingTxs(height uint64, result ExtractResult) {
+
+	bs.addConfirmingTx(result.TxID, height, result.extractData)
+
+	for key, list := range result.extractTokenData {
+		for _, data := range list {
+			bs.addConfirmingTx(result.TxID+"_"+data.Transaction.Coin.ContractID+"_"+key, height, map[string]*openwallet.TxExtractData{key: data})
+		}
+	}
+}
+
+//addConfirmingTx 记录等待确认的交易
+func (bs *BlockScanner) addConfirmingTx(txid string, height uint64, extractData map[string]*openwallet.TxExtractData) {
+
+	if !bs.isConfirmTracking() {
+		return
+	}
+
+	if len(extractData) == 0 {
+		return
+	}
+
+	bs.confirmMu.Lock()
+	defer bs.confirmMu.Unlock()
+
+	bs.confirmingTxs[txid] = &confirmingTx{
+		height:      height,
+		extractData: extractData,
+	}
+}
+
+//removeConfirmingTxs 删除分叉高度及以上的等待确认交易
+func (bs *BlockScanner) removeConfirmingTxs(forkHeight uint64) {
+
+	bs.confirmMu.Lock()
+	defer bs.confirmMu.Unlock()
+
+	for txid, ctTx := range bs.confirmingTxs {
+		if ctTx.height >= forkHeight {
+			delete(bs.confirmingTxs, txid)
+		}
+	}
+}
+
+//NotifyConfirmedTransactions 检查等待确认的交易，达到确认深度或不可逆高度的，通知观测者
+//通知失败的交易保留到下次重试，完成后保存确认检查点，重启后检查点以上已确认的交易可能再次通知
+func (bs *BlockScanner) NotifyConfirmedTransactions() error {
+
+	if !bs.isConfirmTracking() {
+		return nil
+	}
+
+	//重启后从检查点重建等待确认的交易
+	if !bs.confirmRestored {
+		if err := bs.restoreConfirmingTxs(); err != nil {
+			bs.wm.Log.Std.Error("block scanner restore confirming transactions failed; unexpected error: %v", err)
+			return err
+		}
+		bs.confirmRestored = true
+	}
+
+	status, err := bs.wm.RPC.GetBlockChainStatus()
+	if err != nil {
+		bs.wm.Log.Errorf("GetBlockChainStatus failed, err: %v", err)
+		return err
+	}
+
+	tipHeight := uint64(status.GetBlock().GetHeight())
+	irreversibleHeight := uint64(0)
+	if bs.wm.Config.UseIrreversibleHeight {
+		irreversibleHeight = uint64(status.GetMeta().GetIrreversibleBlockHeight())
+	}
+
+	confirmed := make(map[string]*confirmingTx)
+
+	bs.confirmMu.Lock()
+	for txid, ctTx := range bs.confirmingTxs {
+		if bs.isTxConfirmed(ctTx.height, tipHeight, irreversibleHeight) {
+			confirmed[txid] = ctTx
+			delete(bs.confirmingTxs, txid)
+		}
+	}
+	bs.confirmMu.Unlock()
+
+	for txid, ctTx := range confirmed {
+
+		confirms := int64(0)
+		if tipHeight >= ctTx.height {
+			confirms = int64(tipHeight - ctTx.height + 1)
+		}
+
+		for _, data := range ctTx.extractData {
+			if data.Transaction != nil {
+				data.Transaction.Confirm = confirms
+			}
+		}
+
+		bs.wm.Log.Std.Info("transaction: %s has been confirmed on height: %d, confirms: %d", txid, ctTx.height, confirms)
+
+		//只向通知失败的观测者重试失败的数据，不重扫区块
+		failed := bs.newConfirmDataNotify(ctTx)
+		if len(failed) > 0 {
+			bs.confirmMu.Lock()
+			bs.confirmingTxs[txid] = &confirmingTx{height: ctTx.height, extractData: ctTx.extractData, failed: failed}
+			bs.confirmMu.Unlock()
+		}
+	}
+
+	bs.saveConfirmCheckpoint(bs.confirmCheckpoint(tipHeight, irreversibleHeight))
+
+	return nil
+}
+
+//isTxConfirmed 交易是否已达到确认条件
+func (bs *BlockScanner) isTxConfirmed(height, tipHeight, irreversibleHeight uint64) bool {
+
+	//已进入不可逆区块
+	if irreversibleHeight > 0 && height <= irreversibleHeight {
+		return true
+	}
+
+	if bs.wm.Config.ConfirmDepth == 0 {
+		//链上没有不可逆高度，也没有配置确认深度，出块即确认
+		return irreversibleHeight == 0
+	}
+
+	if tipHeight < height {
+		return false
+	}
+
+	return tipHeight-height+1 >= bs.wm.Config.ConfirmDepth
+}
+
+//confirmCheckpoint 确认检查点，该高度及以下的交易都已完成确认通知
+//不超过已确认的高度、已扫描的高度及等待确认交易的最低高度
+func (bs *BlockScanner) confirmCheckpoint(tipHeight, irreversibleHeight uint64) uint64 {
+
+	checkpoint := irreversibleHeight
+	if bs.wm.Config.ConfirmDepth > 0 && tipHeight+1 >= bs.wm.Config.ConfirmDepth {
+		if depthHeight := tipHeight + 1 - bs.wm.Config.ConfirmDepth; depthHeight > checkpoint {
+			checkpoint = depthHeight
+		}
+	}
+
+	if scannedHeight, _, err := bs.GetLocalBlockHead(); err == nil && scannedHeight < checkpoint {
+		checkpoint = scannedHeight
+	}
+
+	bs.confirmMu.Lock()
+	for _, ctTx := range bs.confirmingTxs {
+		if ctTx.height <= checkpoint {
+			checkpoint = 0
+			if ctTx.height > 0 {
+				checkpoint = ctTx.height - 1
+			}
+		}
+	}
+	bs.confirmMu.Unlock()
+
+	return checkpoint
+}
+
+//saveConfirmCheckpoint 保存确认检查点，使用独立的区块头记录，不影响主扫描高度
+func (bs *BlockScanner) saveConfirmCheckpoint(height uint64) {
+
+	if bs.BlockchainDAI == nil || height == 0 {
+		return
+	}
+
+	header := &openwallet.BlockHeader{
+		Height: height,
+		Symbol: bs.wm.Symbol() + confirmCheckpointSuffix,
+	}
+
+	if err := bs.BlockchainDAI.SaveCurrentBlockHead(header); err != nil {
+		bs.wm.Log.Std.Error("block scanner save confirm checkpoint failed; unexpected error: %v", err)
+	}
+}
+
+//restoreConfirmingTxs 重新提取确认检查点到已扫描高度之间的区块，重建等待确认的交易，不通知观测者
+//超过确认深度的区块已经确认，最多重新提取确认深度内的区块
+func (bs *BlockScanner) restoreConfirmingTxs() error {
+
+	if bs.BlockchainDAI == nil {
+		return nil
+	}
+
+	header, err := bs.BlockchainDAI.GetCurrentBlockHead(bs.wm.Symbol() + confirmCheckpointSuffix)
+	if err != nil || header == nil || header.Height == 0 {
+		//没有检查点，从当前扫描高度开始追踪
+		return nil
+	}
+
+	scannedHeight, _, err := bs.GetLocalBlockHead()
+	if err != nil {
+		return err
+	}
+
+	start := header.Height + 1
+	depth := bs.wm.Config.ConfirmDepth
+	if depth > 0 && scannedHeight+1 > depth && scannedHeight+1-depth > start {
+		start = scannedHeight + 1 - depth
+	}
+
+	for height := start; height <= scannedHeight; height++ {
+		block, getErr := bs.wm.RPC.GetBlockByHeight(int64(height))
+		if getErr != nil {
+			return getErr
+		}
+		bs.restoreBlockConfirmingTxs(block)
+	}
+
+	bs.wm.Log.Std.Info("block scanner restored confirming transactions from height: %d to %d", start, scannedHeight)
+
+	return nil
+}
+
+//restoreBlockConfirmingTxs 提取区块中的关注交易，记录为等待确认
+func (bs *BlockScanner) restoreBlockConfirmingTxs(block *pb.InternalBlock) {
+	for _, tx := range block.GetTransactions() {
+		result := bs.ExtractTransaction(block, tx, bs.ScanTargetFuncV2)
+		if !result.Success {
+			continue
+		}
+		bs.trackConfirmingTxs(uint64(block.GetHeight()), result)
+	}
+}
+
+//newConfirmDataNotify 发送交易确认通知，只通知实现了确认接口的观测者
+//重试时只通知上次失败且仍在观测的观测者，返回每个观测者通知失败的数据
+func (bs *BlockScanner) newConfirmDataNotify(ctTx *confirmingTx) confirmNotifyFailures {
+
+	pending := make(confirmNotifyFailures)
+	for _, co := range bs.confirmObservers() {
+		if ctTx.failed == nil {
+			pending[co] = ctTx.extractData
+		} else if data, ok := ctTx.failed[co]; ok {
+			pending[co] = data
+		}
+	}
+
+	failed := make(confirmNotifyFailures)
+	for co, extractData := range pending {
+		for key, data := range extractData {
+			err := co.BlockExtractDataConfirmNotify(key, data)
+			if err != nil {
+				bs.wm.Log.Error("BlockExtractDataConfirmNotify unexpected error:", err)
+				if failed[co] == nil {
+					failed[co] = make(map[string]*openwallet.TxExtractData)
+				}
+				failed[co][key] = data
+			}
+		}
+	}
+
+	return failed
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"testing"
+)
+
+//testObserver 只接收提取通知的观测者
+type testObserver struct {
+	extracted int
+}
+
+func (o *testObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	return nil
+}
+
+func (o *testObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.extracted++
+	return nil
+}
+
+func (o *testObserver) BlockExtractSmartContractDataNotify(sourceKey string, data *openwallet.SmartContractReceipt) error {
+	return nil
+}
+
+//testConfirmObserver 接收确认通知的观测者
+type testConfirmObserver struct {
+	testObserver
+	confirmed int
+	fail      bool
+}
+
+func (o *testConfirmObserver) BlockExtractDataConfirmNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	if o.fail {
+		return fmt.Errorf("confirm notify failed")
+	}
+	o.confirmed++
+	return nil
+}
+
+func newTestConfirmScanner(depth uint64) *BlockScanner {
+	wm := NewWalletManager()
+	wm.Config.ConfirmDepth = depth
+	return wm.BlockScanner.(*BlockScanner)
+}
+
+func TestBlockScanner_isTxConfirmed(t *testing.T) {
+	bs := newTestConfirmScanner(6)
+
+	if !bs.isTxConfirmed(100, 100, 100) {
+		t.Errorf("irreversible tx should be confirmed")
+	}
+	if bs.isTxConfirmed(100, 104, 0) {
+		t.Errorf("tx with 5 confirms should not be confirmed")
+	}
+	if !bs.isTxConfirmed(100, 105, 0) {
+		t.Errorf("tx with 6 confirms should be confirmed")
+	}
+
+	bs.wm.Config.ConfirmDepth = 0
+	if bs.isTxConfirmed(100, 105, 99) {
+		t.Errorf("tx above irreversible height should not be confirmed")
+	}
+	if !bs.isTxConfirmed(100, 100, 0) {
+		t.Errorf("tx should be confirmed without depth and irreversible height")
+	}
+}
+
+func TestBlockScanner_newConfirmDataNotify(t *testing.T) {
+	bs := newTestConfirmScanner(6)
+	data := map[string]*openwallet.TxExtractData{
+		"account": {Transaction: &openwallet.Transaction{TxID: "ab"}},
+	}
+
+	//没有实现确认接口的观测者，不追踪也不通知
+	plain := &testObserver{}
+	bs.AddObserver(plain)
+	bs.addConfirmingTx("ab", 10, data)
+	if len(bs.confirmingTxs) != 0 {
+		t.Errorf("confirming txs should not be tracked without confirm observers")
+	}
+
+	confirm := &testConfirmObserver{}
+	bs.AddObserver(confirm)
+	bs.addConfirmingTx("ab", 10, data)
+	if len(bs.confirmingTxs) != 1 {
+		t.Errorf("confirming txs should be tracked")
+	}
+
+	ctTx := &confirmingTx{height: 10, extractData: data}
+	failed := bs.newConfirmDataNotify(ctTx)
+	if len(failed) != 0 || confirm.confirmed != 1 || plain.extracted != 0 {
+		t.Errorf("only confirm observers should be notified, confirmed: %d, extracted: %d", confirm.confirmed, plain.extracted)
+	}
+
+	//通知失败返回失败的观测者及数据，等待重试
+	other := &testConfirmObserver{fail: true}
+	bs.AddObserver(other)
+	failed = bs.newConfirmDataNotify(ctTx)
+	if len(failed) != 1 || failed[other]["account"] == nil || confirm.confirmed != 2 {
+		t.Errorf("failed observer and data should be returned for retry")
+		return
+	}
+
+	//重试只通知失败的观测者
+	other.fail = false
+	failed = bs.newConfirmDataNotify(&confirmingTx{height: 10, extractData: data, failed: failed})
+	if len(failed) != 0 || confirm.confirmed != 2 || other.confirmed != 1 {
+		t.Errorf("retry should only notify failed observers, confirmed: %d, %d", confirm.confirmed, other.confirmed)
+	}
+}
+
+func TestBlockScanner_confirmCheckpoint(t *testing.T) {
+	bs := newTestConfirmScanner(6)
+	bs.AddObserver(&testConfirmObserver{})
+
+	//确认深度6，高度105时100及以下已确认
+	if checkpoint := bs.confirmCheckpoint(105, 0); checkpoint != 100 {
+		t.Errorf("unexpected checkpoint: %d", checkpoint)
+	}
+
+	//不可逆高度更高时使用不可逆高度
+	if checkpoint := bs.confirmCheckpoint(105, 103); checkpoint != 103 {
+		t.Errorf("unexpected checkpoint: %d", checkpoint)
+	}
+
+	//等待重试的交易不越过检查点
+	bs.addConfirmingTx("ab", 98, map[string]*openwallet.TxExtractData{
+		"account": {Transaction: &openwallet.Transaction{TxID: "ab"}},
+	})
+	if checkpoint := bs.confirmCheckpoint(105, 103); checkpoint != 97 {
+		t.Errorf("unexpected checkpoint: %d", checkpoint)
+	}
+
+	//创世高度的交易不会下溢
+	bs.addConfirmingTx("cd", 0, map[string]*openwallet.TxExtractData{
+		"account": {Transaction: &openwallet.Transaction{TxID: "cd"}},
+	})
+	if checkpoint := bs.confirmCheckpoint(105, 103); checkpoint != 0 {
+		t.Errorf("unexpected checkpoint: %d", checkpoint)
+	}
+}
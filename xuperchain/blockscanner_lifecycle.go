@@ -0,0 +1,138 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//Run 运行扫描器，开启区块事件订阅时由订阅驱动扫描，订阅不可用时由定时任务轮询
+//配置了平行链时同时启动平行链的扫描器，重启前未完成的范围重扫从检查点继续
+func (bs *BlockScanner) Run() error {
+	bs.runParallelChains()
+	if bs.wm.Config.EnableEventSubscribe {
+		bs.startSubscribe()
+	}
+	if bs.BlockchainDAI != nil {
+		if err := bs.ResumeScanBlockRange(); err != nil {
+			bs.wm.Log.Std.Info("block range scanner is not resumed: %v", err)
+		}
+	}
+	return bs.BlockScannerBase.Run()
+}
+
+//Stop 停止扫描器、区块事件订阅及范围重扫，同时停止平行链的扫描器
+func (bs *BlockScanner) Stop() error {
+	for _, scanner := range bs.parallelScanners() {
+		scanner.Stop()
+	}
+	bs.stopSubscribe()
+	bs.StopScanBlockRange()
+	return bs.BlockScannerBase.Stop()
+}
+
+//parallelScanners 平行链的区块扫描器
+func (bs *BlockScanner) parallelScanners() []openwallet.BlockScanner {
+	scanners := make([]openwallet.BlockScanner, 0, len(bs.wm.chains))
+	for _, chain := range bs.wm.chains {
+		scanners = append(scanners, chain.BlockScanner)
+	}
+	return scanners
+}
+
+//runParallelChains 启动平行链的扫描器，节点上不存在的链不扫描
+func (bs *BlockScanner) runParallelChains() {
+
+	if len(bs.wm.chains) == 0 {
+		return
+	}
+
+	exist := make(map[string]bool)
+	names, err := bs.wm.RPC.GetBlockChains()
+	if err != nil {
+		bs.wm.Log.Std.Error("block scanner can not get block chains; unexpected error: %v", err)
+	}
+	for _, name := range names {
+		exist[name] = true
+	}
+
+	for _, chain := range bs.wm.chains {
+		//获取链列表失败时全部启动，由各自的扫描任务重试
+		if err == nil && !exist[chain.Config.ChainName] {
+			bs.wm.Log.Std.Error("chain: %s does not exist on node, skip scanning", chain.Config.ChainName)
+			continue
+		}
+		if runErr := chain.BlockScanner.Run(); runErr != nil {
+			bs.wm.Log.Std.Error("chain: %s block scanner run failed; unexpected error: %v", chain.Config.ChainName, runErr)
+		}
+	}
+}
+
+//Pause 暂停扫描器及平行链的扫描器
+func (bs *BlockScanner) Pause() error {
+	for _, scanner := range bs.parallelScanners() {
+		scanner.Pause()
+	}
+	return bs.BlockScannerBase.Pause()
+}
+
+//Restart 继续扫描器及平行链的扫描器
+func (bs *BlockScanner) Restart() error {
+	for _, scanner := range bs.parallelScanners() {
+		scanner.Restart()
+	}
+	return bs.BlockScannerBase.Restart()
+}
+
+//AddObserver 添加观测者，同时观测平行链
+func (bs *BlockScanner) AddObserver(obj openwallet.BlockScanNotificationObject) error {
+	for _, scanner := range bs.parallelScanners() {
+		if err := scanner.AddObserver(obj); err != nil {
+			return err
+		}
+	}
+	return bs.BlockScannerBase.AddObserver(obj)
+}
+
+//RemoveObserver 移除观测者，同时移除平行链的观测者
+func (bs *BlockScanner) RemoveObserver(obj openwallet.BlockScanNotificationObject) error {
+	for _, scanner := range bs.parallelScanners() {
+		if err := scanner.RemoveObserver(obj); err != nil {
+			return err
+		}
+	}
+	return bs.BlockScannerBase.RemoveObserver(obj)
+}
+
+//SetBlockchainDAI 设置区块链数据访问，平行链的扫描高度按各自的币种保存
+func (bs *BlockScanner) SetBlockchainDAI(dai openwallet.BlockchainDAI) error {
+	for _, scanner := range bs.parallelScanners() {
+		if err := scanner.SetBlockchainDAI(dai); err != nil {
+			return err
+		}
+	}
+	return bs.BlockScannerBase.SetBlockchainDAI(dai)
+}
+
+//SetBlockScanTargetFuncV2 设置扫描目标，平行链使用相同的扫描目标
+func (bs *BlockScanner) SetBlockScanTargetFuncV2(scanTargetFunc openwallet.BlockScanTargetFuncV2) error {
+	for _, scanner := range bs.parallelScanners() {
+		if err := scanner.SetBlockScanTargetFuncV2(scanTargetFunc); err != nil {
+			return err
+		}
+	}
+	return bs.BlockScannerBase.SetBlockScanTargetFuncV2(scanTargetFunc)
+}
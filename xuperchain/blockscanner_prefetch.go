@@ -0,0 +1,97 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"github.com/xuperchain/xuperchain/core/pb"
+)
+
+//prefetchResult 预下载的区块结果
+type prefetchResult struct {
+	block *pb.InternalBlock
+	err   error
+}
+
+//blockPrefetcher 区块预下载器，并发下载后续区块，按高度顺序取出
+type blockPrefetcher struct {
+	fetch     func(height uint64) (*pb.InternalBlock, error) //下载区块的方法
+	size      int                                            //并发下载数量
+	next      uint64                                         //下一个待下载的高度
+	maxHeight uint64                                         //最大可下载高度
+	pending   map[uint64]chan prefetchResult                 //下载中的区块
+}
+
+//newBlockPrefetcher 创建区块预下载器
+func newBlockPrefetcher(bs *BlockScanner, size int) *blockPrefetcher {
+	if size <= 0 {
+		size = 1
+	}
+	return &blockPrefetcher{
+		fetch: func(height uint64) (*pb.InternalBlock, error) {
+			return bs.wm.RPC.GetBlockByHeight(int64(height))
+		},
+		size:    size,
+		pending: make(map[uint64]chan prefetchResult),
+	}
+}
+
+//SetMaxHeight 设置最大可下载高度
+func (p *blockPrefetcher) SetMaxHeight(maxHeight uint64) {
+	p.maxHeight = maxHeight
+}
+
+//Reset 丢弃已下载的区块，下次从指定高度重新下载
+func (p *blockPrefetcher) Reset(height uint64) {
+	//下载中的结果通道有缓冲，丢弃后协程不会阻塞
+	p.pending = make(map[uint64]chan prefetchResult)
+	p.next = height
+}
+
+//GetBlockByHeight 获取指定高度区块，并预下载后续区块
+func (p *blockPrefetcher) GetBlockByHeight(height uint64) (*pb.InternalBlock, error) {
+
+	//高度不连续，例如分叉回退，重新开始下载
+	if _, ok := p.pending[height]; !ok && p.next != height {
+		p.Reset(height)
+	}
+
+	if p.maxHeight < height {
+		p.maxHeight = height
+	}
+
+	p.fill()
+
+	ch := p.pending[height]
+	delete(p.pending, height)
+	result := <-ch
+
+	p.fill()
+
+	return result.block, result.err
+}
+
+//fill 补充下载任务到并发上限
+func (p *blockPrefetcher) fill() {
+	for len(p.pending) < p.size && p.next <= p.maxHeight {
+		ch := make(chan prefetchResult, 1)
+		p.pending[p.next] = ch
+		go func(h uint64, mCh chan<- prefetchResult) {
+			block, err := p.fetch(h)
+			mCh <- prefetchResult{block: block, err: err}
+		}(p.next, ch)
+		p.next++
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"github.com/xuperchain/xuperchain/core/pb"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testPrefetcher 创建下载耗时随高度递减的预下载器，记录每个高度的下载次数
+func testPrefetcher(size int) (*blockPrefetcher, map[uint64]int, *sync.Mutex) {
+	var (
+		mu      sync.Mutex
+		fetched = make(map[uint64]int)
+	)
+	p := &blockPrefetcher{
+		size:    size,
+		pending: make(map[uint64]chan prefetchResult),
+	}
+	p.fetch = func(height uint64) (*pb.InternalBlock, error) {
+		//高度越低下载越慢，保证并发下载的完成顺序与高度顺序相反
+		time.Sleep(time.Duration(20-height%20) * time.Millisecond)
+		mu.Lock()
+		fetched[height]++
+		mu.Unlock()
+		return &pb.InternalBlock{Height: int64(height)}, nil
+	}
+	return p, fetched, &mu
+}
+
+func TestBlockPrefetcher_InOrder(t *testing.T) {
+	p, fetched, mu := testPrefetcher(4)
+	p.Reset(10)
+	p.SetMaxHeight(30)
+
+	for h := uint64(10); h <= 30; h++ {
+		block, err := p.GetBlockByHeight(h)
+		if err != nil {
+			t.Errorf("GetBlockByHeight failed, err: %v", err)
+			return
+		}
+		if uint64(block.GetHeight()) != h {
+			t.Errorf("expected block %d, got %d", h, block.GetHeight())
+			return
+		}
+		if len(p.pending) > p.size {
+			t.Errorf("pending %d exceeds size %d", len(p.pending), p.size)
+			return
+		}
+	}
+
+	if len(p.pending) != 0 || p.next != 31 {
+		t.Errorf("should not prefetch beyond max height, pending: %d, next: %d", len(p.pending), p.next)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for h := uint64(10); h <= 30; h++ {
+		if fetched[h] != 1 {
+			t.Errorf("block %d fetched %d times", h, fetched[h])
+			return
+		}
+	}
+}
+
+func TestBlockPrefetcher_Reset(t *testing.T) {
+	p, fetched, mu := testPrefetcher(4)
+	p.Reset(100)
+	p.SetMaxHeight(120)
+
+	for h := uint64(100); h <= 102; h++ {
+		if _, err := p.GetBlockByHeight(h); err != nil {
+			t.Errorf("GetBlockByHeight failed, err: %v", err)
+			return
+		}
+	}
+
+	//分叉回退到更低的高度，丢弃已下载的区块重新下载
+	block, err := p.GetBlockByHeight(98)
+	if err != nil || block.GetHeight() != 98 {
+		t.Errorf("fork height: %d, err: %v", block.GetHeight(), err)
+		return
+	}
+	if _, ok := p.pending[103]; ok || p.next != 98+uint64(p.size)+1 {
+		t.Errorf("stale prefetch not discarded, next: %d", p.next)
+		return
+	}
+
+	//跳过中间高度，从不连续的高度重新下载
+	block, err = p.GetBlockByHeight(110)
+	if err != nil || block.GetHeight() != 110 {
+		t.Errorf("jump height: %d, err: %v", block.GetHeight(), err)
+		return
+	}
+
+	//同一高度重新获取，例如分叉后重新获取新的区块
+	block, err = p.GetBlockByHeight(110)
+	if err != nil || block.GetHeight() != 110 {
+		t.Errorf("same height: %d, err: %v", block.GetHeight(), err)
+		return
+	}
+
+	//等待被丢弃的下载完成
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched[98] != 1 || fetched[110] != 2 {
+		t.Errorf("unexpected fetch count, 98: %d, 110: %d", fetched[98], fetched[110])
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"strconv"
+	"strings"
+)
+
+const (
+	rangeCheckpointSuffix = "_RANGE" //范围重扫检查点的标识后缀
+)
+
+//BlockScanRangeProgress 区块范围重扫进度
+type BlockScanRangeProgress struct {
+	From    uint64 //起始高度
+	To      uint64 //结束高度
+	Current uint64 //已完成的高度
+	Failed  uint64 //失败的区块数量
+	Running bool   //是否运行中
+}
+
+//Percent 完成百分比
+func (p BlockScanRangeProgress) Percent() float64 {
+	if p.To < p.From {
+		return 0
+	}
+	total := p.To - p.From + 1
+	if p.Current < p.From {
+		return 0
+	}
+	return float64(p.Current-p.From+1) * 100 / float64(total)
+}
+
+//ScanBlockRange 后台重扫指定范围区块，不影响主扫描高度
+func (bs *BlockScanner) ScanBlockRange(from, to uint64) error {
+
+	if from == 0 || from > to {
+		return fmt.Errorf("block range [%d, %d] is invalid", from, to)
+	}
+
+	bs.rangeMu.Lock()
+	defer bs.rangeMu.Unlock()
+
+	if bs.rangeProgress.Running {
+		return fmt.Errorf("block range [%d, %d] is scanning", bs.rangeProgress.From, bs.rangeProgress.To)
+	}
+
+	//记录检查点，重启后可继续
+	err := bs.saveRangeCheckpoint(from, to, from-1)
+	if err != nil {
+		return err
+	}
+
+	bs.startScanBlockRange(from, to, from)
+
+	return nil
+}
+
+//ResumeScanBlockRange 从检查点继续未完成的范围重扫
+func (bs *BlockScanner) ResumeScanBlockRange() error {
+
+	from, to, current, err := bs.getRangeCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	bs.rangeMu.Lock()
+	defer bs.rangeMu.Unlock()
+
+	if bs.rangeProgress.Running {
+		return fmt.Errorf("block range [%d, %d] is scanning", bs.rangeProgress.From, bs.rangeProgress.To)
+	}
+
+	//已完成
+	if current >= to {
+		return nil
+	}
+
+	bs.startScanBlockRange(from, to, current+1)
+
+	return nil
+}
+
+//StopScanBlockRange 停止范围重扫，检查点保留
+func (bs *BlockScanner) StopScanBlockRange() {
+
+	bs.rangeMu.Lock()
+	defer bs.rangeMu.Unlock()
+
+	if bs.rangeProgress.Running && bs.rangeQuit != nil {
+		close(bs.rangeQuit)
+		bs.rangeQuit = nil
+	}
+}
+
+//GetScanBlockRangeProgress 获取范围重扫进度
+func (bs *BlockScanner) GetScanBlockRangeProgress() BlockScanRangeProgress {
+
+	bs.rangeMu.Lock()
+	defer bs.rangeMu.Unlock()
+
+	return bs.rangeProgress
+}
+
+//startScanBlockRange 启动范围重扫线程，调用前需持有rangeMu
+func (bs *BlockScanner) startScanBlockRange(from, to, start uint64) {
+
+	quit := make(chan struct{})
+	bs.rangeQuit = quit
+	bs.rangeProgress = BlockScanRangeProgress{
+		From:    from,
+		To:      to,
+		Current: start - 1,
+		Running: true,
+	}
+
+	go bs.scanBlockRangeWork(from, to, start, quit)
+}
+
+//scanBlockRangeWork 范围重扫工作
+func (bs *BlockScanner) scanBlockRangeWork(from, to, start uint64, quit chan struct{}) {
+
+	defer func() {
+		bs.rangeMu.Lock()
+		bs.rangeProgress.Running = false
+		bs.rangeMu.Unlock()
+	}()
+
+	for height := start; height <= to; height++ {
+
+		select {
+		case <-quit:
+			bs.wm.Log.Std.Info("block range scanner stopped on height: %d", height)
+			return
+		default:
+		}
+
+		//提取失败的区块记录到范围重扫的未扫记录，不影响主扫描器
+		err := bs.scanRangeBlock(height)
+
+		bs.rangeMu.Lock()
+		bs.rangeProgress.Current = height
+		if err != nil {
+			bs.rangeProgress.Failed++
+		}
+		progress := bs.rangeProgress
+		bs.rangeMu.Unlock()
+
+		saveErr := bs.saveRangeCheckpoint(from, to, height)
+		if saveErr != nil {
+			bs.wm.Log.Std.Error("block range scanner save checkpoint failed, unexpected error: %v", saveErr)
+		}
+
+		bs.wm.Log.Std.Info("block range scanner progress: %d/%d (%.2f%%)", height, to, progress.Percent())
+	}
+
+	bs.wm.Log.Std.Info("block range scanner has scanned range [%d, %d]", from, to)
+}
+
+//rangeUnscanSymbol 范围重扫未扫记录的标识，与主扫描器的未扫记录分开
+func (bs *BlockScanner) rangeUnscanSymbol() string {
+	return bs.wm.Symbol() + rangeCheckpointSuffix
+}
+
+//scanRangeBlock 范围重扫一个区块，历史交易不追踪确认，与主扫描并行下载区块，提取通知时由extractMu互斥
+func (bs *BlockScanner) scanRangeBlock(height uint64) error {
+
+	block, err := bs.wm.RPC.GetBlockByHeight(int64(height))
+	if err != nil {
+		bs.wm.Log.Std.Info("block range scanner can not get block data; unexpected error: %v", err)
+		unscanRecord := openwallet.NewUnscanRecord(height, "", err.Error(), bs.rangeUnscanSymbol())
+		bs.SaveUnscanRecord(unscanRecord)
+		return err
+	}
+
+	return bs.batchExtractTransaction(block, blockExtractOption{trackConfirm: false, unscanSymbol: bs.rangeUnscanSymbol()})
+}
+
+//RescanFailedRangeRecords 重扫范围重扫失败的区块
+func (bs *BlockScanner) RescanFailedRangeRecords() error {
+
+	if bs.BlockchainDAI == nil {
+		return fmt.Errorf("Blockchain DAI is not setup ")
+	}
+
+	list, err := bs.BlockchainDAI.GetUnscanRecords(bs.rangeUnscanSymbol())
+	if err != nil {
+		return err
+	}
+
+	heights := make(map[uint64]bool)
+	for _, record := range list {
+		heights[record.BlockHeight] = true
+	}
+
+	for height := range heights {
+		//先删除旧记录，重扫失败会重新记录
+		bs.BlockchainDAI.DeleteUnscanRecordByHeight(height, bs.rangeUnscanSymbol())
+		if scanErr := bs.scanRangeBlock(height); scanErr != nil {
+			bs.wm.Log.Std.Info("block range scanner rescan height: %d failed; unexpected error: %v", height, scanErr)
+		}
+	}
+
+	return nil
+}
+
+//saveRangeCheckpoint 保存范围重扫检查点，使用独立的区块头记录，不影响主扫描高度
+func (bs *BlockScanner) saveRangeCheckpoint(from, to, current uint64) error {
+
+	if bs.BlockchainDAI == nil {
+		return fmt.Errorf("Blockchain DAI is not setup ")
+	}
+
+	header := &openwallet.BlockHeader{
+		Hash:   fmt.Sprintf("%d:%d", from, to),
+		Height: current,
+		Symbol: bs.wm.Symbol() + rangeCheckpointSuffix,
+	}
+
+	return bs.BlockchainDAI.SaveCurrentBlockHead(header)
+}
+
+//getRangeCheckpoint 获取范围重扫检查点
+func (bs *BlockScanner) getRangeCheckpoint() (uint64, uint64, uint64, error) {
+
+	if bs.BlockchainDAI == nil {
+		return 0, 0, 0, fmt.Errorf("Blockchain DAI is not setup ")
+	}
+
+	header, err := bs.BlockchainDAI.GetCurrentBlockHead(bs.wm.Symbol() + rangeCheckpointSuffix)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if header == nil {
+		return 0, 0, 0, fmt.Errorf("block range checkpoint is not found")
+	}
+
+	blockRange := strings.Split(header.Hash, ":")
+	if len(blockRange) != 2 {
+		return 0, 0, 0, fmt.Errorf("block range checkpoint is not found")
+	}
+
+	from, err := strconv.ParseUint(blockRange[0], 10, 64)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	to, err := strconv.ParseUint(blockRange[1], 10, 64)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return from, to, header.Height, nil
+}
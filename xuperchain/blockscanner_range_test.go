@@ -0,0 +1,79 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"math/big"
+	"testing"
+)
+
+func testRangeBlock() *pb.InternalBlock {
+	tx := &pb.Transaction{
+		Txid: []byte{0x01, 0x02},
+		TxOutputs: []*pb.TxOutput{
+			{ToAddr: []byte("addr1"), Amount: big.NewInt(100000000).Bytes()},
+		},
+	}
+	return &pb.InternalBlock{
+		Blockid:      []byte{0x0a},
+		Height:       100,
+		Transactions: []*pb.Transaction{tx},
+	}
+}
+
+func TestBlockScanner_batchExtractTransaction_range(t *testing.T) {
+	bs := newTestConfirmScanner(6)
+	observer := &testConfirmObserver{}
+	bs.AddObserver(observer)
+	bs.SetBlockScanTargetFuncV2(func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		if target.ScanTarget == "addr1" {
+			return openwallet.ScanTargetResult{SourceKey: "account", Exist: true}
+		}
+		return openwallet.ScanTargetResult{}
+	})
+
+	//范围重扫的历史交易不追踪确认
+	err := bs.batchExtractTransaction(testRangeBlock(), blockExtractOption{trackConfirm: false, unscanSymbol: bs.rangeUnscanSymbol()})
+	if err != nil {
+		t.Errorf("batchExtractTransaction failed, err: %v", err)
+		return
+	}
+	if observer.extracted != 1 {
+		t.Errorf("range block should be notified, extracted: %d", observer.extracted)
+	}
+	if len(bs.confirmingTxs) != 0 {
+		t.Errorf("range block should not track confirming txs")
+	}
+
+	//主扫描追踪确认
+	err = bs.BatchExtractTransaction(testRangeBlock())
+	if err != nil {
+		t.Errorf("BatchExtractTransaction failed, err: %v", err)
+		return
+	}
+	if len(bs.confirmingTxs) != 1 {
+		t.Errorf("main scanner should track confirming txs")
+	}
+}
+
+func TestBlockScanner_rangeUnscanSymbol(t *testing.T) {
+	bs := newTestConfirmScanner(0)
+	if bs.rangeUnscanSymbol() == bs.wm.Symbol() {
+		t.Errorf("range unscan records should not use main scanner symbol")
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"github.com/blocktree/xuperchain-adapter/xuperchain_rpc"
+	"time"
+)
+
+const (
+	subscribeRetryInterval = 5 * time.Second //区块事件订阅断开后重新订阅的间隔
+)
+
+//IsSubscribed 是否正在由区块事件订阅驱动扫描
+func (bs *BlockScanner) IsSubscribed() bool {
+	bs.subscribeMu.Lock()
+	defer bs.subscribeMu.Unlock()
+	return bs.subscribed
+}
+
+//pollBlockTask 定时扫描任务，订阅正常时跳过，由订阅驱动扫描
+func (bs *BlockScanner) pollBlockTask() {
+
+	if bs.IsSubscribed() {
+		return
+	}
+
+	bs.scanMu.Lock()
+	defer bs.scanMu.Unlock()
+
+	bs.ScanBlockTask()
+}
+
+//setSubscribed 设置订阅状态
+func (bs *BlockScanner) setSubscribed(subscribed bool) {
+	bs.subscribeMu.Lock()
+	defer bs.subscribeMu.Unlock()
+	bs.subscribed = subscribed
+}
+
+//startSubscribe 启动区块事件订阅线程
+func (bs *BlockScanner) startSubscribe() {
+
+	bs.subscribeMu.Lock()
+	defer bs.subscribeMu.Unlock()
+
+	if bs.subscribeQuit != nil {
+		return
+	}
+
+	quit := make(chan struct{})
+	bs.subscribeQuit = quit
+
+	go bs.subscribeWork(quit)
+}
+
+//stopSubscribe 停止区块事件订阅线程
+func (bs *BlockScanner) stopSubscribe() {
+
+	bs.subscribeMu.Lock()
+	defer bs.subscribeMu.Unlock()
+
+	if bs.subscribeQuit != nil {
+		close(bs.subscribeQuit)
+		bs.subscribeQuit = nil
+	}
+	bs.subscribed = false
+}
+
+//subscribeWork 订阅工作，断开后从已扫描的高度重新订阅，节点不支持订阅时退出，由定时任务轮询
+func (bs *BlockScanner) subscribeWork(quit chan struct{}) {
+
+	for {
+
+		err := bs.subscribeBlocks(quit)
+		bs.setSubscribed(false)
+
+		select {
+		case <-quit:
+			bs.wm.Log.Std.Info("block scanner event subscription stopped")
+			return
+		default:
+		}
+
+		if xuperchain_rpc.IsSubscribeUnsupported(err) {
+			bs.wm.Log.Std.Info("node does not support event subscription, block scanner falls back to polling")
+			return
+		}
+
+		bs.wm.Log.Std.Info("block scanner event subscription disconnected, unexpected error: %v", err)
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(subscribeRetryInterval):
+		}
+	}
+}
+
+//subscribeBlocks 从本地扫描高度的下一个区块开始订阅，每收到新区块扫描到该高度
+func (bs *BlockScanner) subscribeBlocks(quit chan struct{}) error {
+
+	blockHeader, err := bs.GetScannedBlockHeader()
+	if err != nil {
+		return err
+	}
+
+	sub, err := bs.wm.RPC.SubscribeBlocks(int64(blockHeader.Height + 1))
+	if err != nil {
+		return err
+	}
+
+	//停止或长时间没有收到区块事件时关闭订阅，结束阻塞的Recv
+	done := make(chan struct{})
+	received := make(chan struct{}, 1)
+	defer close(done)
+	go func() {
+		staleTimeout := bs.wm.Config.SubscribeStaleTimeout
+		if waitSubscriptionStale(staleTimeout, received, quit, done) {
+			bs.wm.Log.Std.Info("block scanner event subscription received no block in %v, resume polling", staleTimeout)
+			bs.setSubscribed(false)
+		}
+		sub.Close()
+	}()
+
+	for {
+		block, recvErr := sub.Recv()
+		if recvErr != nil {
+			return recvErr
+		}
+
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+
+		//收到区块事件，订阅可用，暂停定时轮询
+		bs.setSubscribed(true)
+
+		if !bs.Scanning {
+			continue
+		}
+
+		height := uint64(block.BlockHeight)
+		bs.scanMu.Lock()
+		bs.scanBlocks(func() (uint64, error) {
+			return height, nil
+		})
+		bs.scanMu.Unlock()
+	}
+}
+
+//waitSubscriptionStale 等待订阅结束，每次收到区块事件重新计时，超时没有收到区块事件时返回true，timeout为0时不检查
+func waitSubscriptionStale(timeout time.Duration, received, quit, done <-chan struct{}) bool {
+
+	if timeout <= 0 {
+		select {
+		case <-quit:
+		case <-done:
+		}
+		return false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return false
+		case <-done:
+			return false
+		case <-received:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			return true
+		}
+	}
+}
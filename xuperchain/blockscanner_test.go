@@ -17,8 +17,13 @@ package xuperchain
 
 import (
 	"encoding/hex"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/blocktree/openwallet/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBlockScanner_tx_outputs_ext(t *testing.T) {
@@ -47,3 +52,311 @@ func TestBlockScanner_tx_outputs_ext(t *testing.T) {
 	}
 
 }
+
+func TestTxExecutionStatus(t *testing.T) {
+	trx := &pb.Transaction{Txid: []byte{0x01, 0x02}, Timestamp: 1590000000000000000}
+	block := &pb.InternalBlock{
+		Timestamp: 1600000000000000000,
+		FailedTxs: map[string]string{"0102": "contract error status:500"},
+	}
+
+	status, reason := txExecutionStatus(block, trx)
+	if status != openwallet.TxStatusFail || reason != "contract error status:500" {
+		t.Errorf("failed transaction status: %s, reason: %s", status, reason)
+		return
+	}
+
+	status, _ = txExecutionStatus(&pb.InternalBlock{}, trx)
+	if status != openwallet.TxStatusSuccess {
+		t.Errorf("success transaction status: %s", status)
+		return
+	}
+
+	if confirmTime := blockConfirmTime(block, trx); confirmTime != 1600000000 {
+		t.Errorf("confirm time: %d", confirmTime)
+		return
+	}
+}
+
+func TestFailedTxids(t *testing.T) {
+	//节点打包时合约执行失败的wasm调用，只在FailedTxs中记录txid及合约返回的错误
+	failedTx := &pb.Transaction{
+		Txid:      []byte{0x9a, 0x3f},
+		Initiator: "nofJPPzVCpDnXixVhLWfEeyzgDDAu9rSo",
+		ContractRequests: []*pb.InvokeRequest{
+			{ModuleName: "wasm", ContractName: "artToyContract2", MethodName: "transfer", Args: map[string][]byte{"to": []byte("Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb"), "amount": []byte("100")}},
+		},
+	}
+	includedTx := &pb.Transaction{Txid: []byte{0x01, 0x02}}
+	block := &pb.InternalBlock{
+		Height:       100,
+		Transactions: []*pb.Transaction{includedTx},
+		FailedTxs: map[string]string{
+			"9a3f": "contract error status:500, message:balance not enough",
+			"0102": "contract error status:500",
+		},
+	}
+
+	//已在交易列表中的交易不重复提取
+	txids := failedTxids(block)
+	if len(txids) != 1 || txids[0] != "9a3f" {
+		t.Errorf("unexpected failed txids: %v", txids)
+		return
+	}
+
+	status, reason := txExecutionStatus(block, failedTx)
+	if status != openwallet.TxStatusFail || reason != "contract error status:500, message:balance not enough" {
+		t.Errorf("failed transaction status: %s, reason: %s", status, reason)
+	}
+}
+
+func TestParseTokenTransfers(t *testing.T) {
+	contract := &openwallet.SmartContract{Address: "wasm:artToyContract2", Decimals: 2}
+	trx := &pb.Transaction{Initiator: "nofJPPzVCpDnXixVhLWfEeyzgDDAu9rSo"}
+
+	//没有transfer事件时解析transfer及transferFrom调用的参数
+	requests := []*pb.InvokeRequest{
+		{MethodName: "transfer", Args: map[string][]byte{"to": []byte("Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb"), "amount": []byte("1050")}},
+		{MethodName: "transferFrom", Args: map[string][]byte{"from": []byte("oG3LjUQRA5UHwzQgrkAriqhbHmv4VAb5D"), "to": []byte("Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb"), "amount": []byte("7")}},
+		{MethodName: "approve", Args: map[string][]byte{"to": []byte("Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb"), "amount": []byte("1")}},
+	}
+	transfers, err := parseTokenTransfers(trx, requests, contract, nil)
+	if err != nil || len(transfers) != 2 {
+		t.Errorf("unexpected transfers: %d, err: %v", len(transfers), err)
+		return
+	}
+	if transfers[0].From != trx.Initiator || transfers[0].To != "Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb" || transfers[0].Amount.String() != "10.5" {
+		t.Errorf("unexpected transfer: %+v", transfers[0])
+	}
+	if transfers[1].From != "oG3LjUQRA5UHwzQgrkAriqhbHmv4VAb5D" || transfers[1].Amount.String() != "0.07" {
+		t.Errorf("unexpected transferFrom: %+v", transfers[1])
+	}
+
+	//数量无效的转账不提取
+	requests = []*pb.InvokeRequest{
+		{MethodName: "transfer", Args: map[string][]byte{"to": []byte("Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb"), "amount": []byte("abc")}},
+		{MethodName: "transfer", Args: map[string][]byte{"to": []byte("Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb")}},
+	}
+	transfers, err = parseTokenTransfers(trx, requests, contract, nil)
+	if err == nil || len(transfers) != 0 {
+		t.Errorf("invalid amount should be skipped, transfers: %d, err: %v", len(transfers), err)
+		return
+	}
+
+	//evm调用的参数为abi编码，没有事件时不解析
+	requests = []*pb.InvokeRequest{
+		{ModuleName: MODULE_EVM, MethodName: "transfer", Args: map[string][]byte{"input": []byte("a9059cbb")}},
+	}
+	transfers, err = parseTokenTransfers(trx, requests, contract, nil)
+	if err != nil || len(transfers) != 0 {
+		t.Errorf("evm transfer should not be parsed from args, transfers: %d, err: %v", len(transfers), err)
+	}
+}
+
+func TestDecodeEventFields(t *testing.T) {
+	abiJSON := `[{"anonymous":false,"inputs":[{"name":"from","type":"string"},{"name":"to","type":"string"},{"name":"amount","type":"uint256"}],"name":"transfer","type":"event"},{"anonymous":false,"inputs":[{"name":"paused","type":"bool"}],"name":"pause","type":"event"}]`
+	abiInstance, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		t.Errorf("abi.JSON failed, err: %v", err)
+		return
+	}
+
+	fields, err := decodeEventFields(abiInstance.Events["transfer"], []byte(`{"from":"a","to":"b","amount":1000}`))
+	if err != nil {
+		t.Errorf("decodeEventFields failed, err: %v", err)
+		return
+	}
+	if fields["amount"] != "1000" || fields["to"] != "b" {
+		t.Errorf("decodeEventFields fields: %v", fields)
+		return
+	}
+
+	fields, err = decodeEventFields(abiInstance.Events["pause"], []byte(`true`))
+	if err != nil || fields["paused"] != true {
+		t.Errorf("decodeEventFields single input fields: %v, err: %v", fields, err)
+		return
+	}
+
+	_, err = decodeEventFields(abiInstance.Events["transfer"], []byte(`{"from":"a","amount":-1}`))
+	if err == nil {
+		t.Errorf("decodeEventFields should fail with invalid event value")
+		return
+	}
+}
+
+func TestBlockScanner_extractStateChanges(t *testing.T) {
+	bs := &BlockScanner{wm: &WalletManager{Config: NewConfig(Symbol)}}
+	bs.wm.Config.StateDiffPrefixes = parseStateDiffPrefixes("token/balance_")
+
+	trx := &pb.Transaction{
+		TxInputsExt: []*pb.TxInputExt{
+			{Bucket: "token", Key: []byte("balance_alice"), RefTxid: []byte{0xab}, RefOffset: 1},
+		},
+		TxOutputsExt: []*pb.TxOutputExt{
+			{Bucket: "token", Key: []byte("balance_alice"), Value: []byte("90")},
+			{Bucket: "token", Key: []byte("balance_bob"), Value: []byte("10")},
+			{Bucket: "token", Key: []byte("owner"), Value: []byte("alice")},
+			{Bucket: "token", Key: []byte(EVENT_KEY), Value: []byte("[]")},
+			{Bucket: contractEventBucket, Key: []byte(contractEventKey), Value: []byte{}},
+		},
+	}
+
+	changes := bs.extractStateChanges(trx, MODULE_WASM, func(bucket string) bool { return true }, make(map[int]bool))
+	if len(changes) != 2 {
+		t.Errorf("state changes length: %d", len(changes))
+		return
+	}
+	if changes[0].OldVersion != "ab_1" || changes[0].Value != hex.EncodeToString([]byte("90")) {
+		t.Errorf("state change: %+v", changes[0])
+		return
+	}
+	if changes[1].OldVersion != "" {
+		t.Errorf("new key old version: %s", changes[1].OldVersion)
+		return
+	}
+}
+
+func TestParseStakingDesc(t *testing.T) {
+	staking, ok := parseStakingDesc([]byte(`{"module":"tdpos","method":"vote","args":{"candidates":["addr1","addr2"]}}`))
+	if !ok {
+		t.Errorf("parseStakingDesc failed")
+		return
+	}
+	if candidates := staking.Candidates(); len(candidates) != 2 || candidates[1] != "addr2" {
+		t.Errorf("vote candidates: %v", candidates)
+		return
+	}
+
+	staking, ok = parseStakingDesc([]byte(`{"module":"tdpos","method":"nominate_candidate","args":{"candidate":"addr1","neturl":"/ip4/127.0.0.1/tcp/47101"}}`))
+	if !ok || len(staking.Candidates()) != 1 {
+		t.Errorf("parseStakingDesc nominate failed")
+		return
+	}
+
+	if _, ok = parseStakingDesc([]byte(TxActionAward)); ok {
+		t.Errorf("award desc should not be staking")
+		return
+	}
+
+	if _, ok = parseStakingDesc([]byte(`{"module":"proposal","method":"Vote","args":{"txid":"ab"}}`)); ok {
+		t.Errorf("proposal desc should not be staking")
+		return
+	}
+}
+
+func TestCoinbaseTxAction(t *testing.T) {
+	//链上出块奖励交易，Desc为节点生成奖励时填写的"1"
+	award := &pb.Transaction{
+		Desc:      []byte("1"),
+		Coinbase:  true,
+		TxOutputs: []*pb.TxOutput{{ToAddr: []byte("dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN"), Amount: []byte{0x3b, 0x9a, 0xca, 0x00}}},
+	}
+	if !isCoinbaseTransaction(award) {
+		t.Errorf("award tx should be coinbase")
+		return
+	}
+	if action := coinbaseTxAction(&pb.InternalBlock{Height: 1024, Transactions: []*pb.Transaction{award}}); action != TxActionAward {
+		t.Errorf("award tx action: %s", action)
+		return
+	}
+
+	//创世块的初始分配
+	genesis := &pb.Transaction{Desc: []byte(`{"maxblocksize":"128"}`), Coinbase: true}
+	if action := coinbaseTxAction(&pb.InternalBlock{Height: 0, Transactions: []*pb.Transaction{genesis}}); action != TxActionCoinbase {
+		t.Errorf("genesis tx action: %s", action)
+		return
+	}
+
+	//有输入的交易不是coinbase
+	if isCoinbaseTransaction(&pb.Transaction{Coinbase: true, TxInputs: []*pb.TxInput{{}}}) {
+		t.Errorf("tx with inputs should not be coinbase")
+	}
+}
+
+func TestWaitSubscriptionStale(t *testing.T) {
+	received := make(chan struct{})
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	//持续收到区块事件时不会超时
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			received <- struct{}{}
+		}
+		close(done)
+	}()
+	if waitSubscriptionStale(50*time.Millisecond, received, quit, done) {
+		t.Errorf("subscription should not be stale while receiving blocks")
+		return
+	}
+
+	//连接保持但不再推送区块事件
+	if !waitSubscriptionStale(50*time.Millisecond, received, quit, make(chan struct{})) {
+		t.Errorf("subscription should be stale without blocks")
+		return
+	}
+
+	close(quit)
+	if waitSubscriptionStale(time.Second, received, quit, make(chan struct{})) {
+		t.Errorf("stopped subscription should not be stale")
+		return
+	}
+
+	//超时为0时不检查
+	if waitSubscriptionStale(0, received, quit, make(chan struct{})) {
+		t.Errorf("subscription should not be stale when check is disabled")
+	}
+}
+
+func TestReceiptExtractData(t *testing.T) {
+	receipts := map[string]*openwallet.SmartContractReceipt{
+		"contract": {
+			TxID:   "ab",
+			From:   "addr1",
+			To:     "token",
+			Fees:   "0.01",
+			Value:  "0",
+			Status: openwallet.TxStatusSuccess,
+			Events: []*openwallet.SmartContractEvent{{Event: "transfer", Value: `{"amount":"1"}`}},
+		},
+	}
+
+	receipts = txStatusReceipts(&pb.TxStatus{Status: pb.TransactionStatus_FURCATION}, receipts)
+	if receipts["contract"].Status != openwallet.TxStatusFail {
+		t.Errorf("furcation receipt status: %s", receipts["contract"].Status)
+		return
+	}
+
+	data := receiptExtractData(receipts["contract"])
+	if data.Transaction == nil || data.Transaction.TxAction != TxActionContractCall || data.Transaction.TxType != TxTypeContractCall || data.Transaction.Status != openwallet.TxStatusFail {
+		t.Errorf("receipt extract data: %+v", data.Transaction)
+		return
+	}
+}
+
+func TestTxStatusExtractData_Unconfirmed(t *testing.T) {
+	unconfirmed := &pb.TxStatus{Status: pb.TransactionStatus_UNCONFIRM}
+
+	receipts := txStatusReceipts(unconfirmed, map[string]*openwallet.SmartContractReceipt{
+		"contract": {TxID: "ab", Status: openwallet.TxStatusSuccess},
+	})
+	if receipts["contract"].Status != TxStatusPending {
+		t.Errorf("unconfirmed receipt status: %s", receipts["contract"].Status)
+	}
+
+	extData := txStatusExtractData(unconfirmed, map[string][]*openwallet.TxExtractData{
+		"account": {{Transaction: &openwallet.Transaction{TxID: "ab", Status: openwallet.TxStatusSuccess}}},
+	})
+	if tx := extData["account"][0].Transaction; tx.Status != TxStatusPending || len(tx.Reason) == 0 {
+		t.Errorf("unconfirmed transaction status: %s", tx.Status)
+	}
+
+	//已确认的交易保持执行结果的状态
+	confirmed := txStatusReceipts(&pb.TxStatus{Status: pb.TransactionStatus_CONFIRM}, map[string]*openwallet.SmartContractReceipt{
+		"contract": {TxID: "ab", Status: openwallet.TxStatusSuccess},
+	})
+	if confirmed["contract"].Status != openwallet.TxStatusSuccess {
+		t.Errorf("confirmed receipt status: %s", confirmed["contract"].Status)
+	}
+}
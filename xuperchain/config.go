@@ -16,6 +16,9 @@ package xuperchain
 
 import (
 	"github.com/blocktree/go-owcrypt"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
 )
 
 const (
@@ -35,6 +38,34 @@ type ChainConfig struct {
 	ChainName string
 	//最大的输入数量
 	MaxTxInputs int
+	//交易确认深度，达到后再次通知观测者，0为不追踪
+	ConfirmDepth uint64
+	//链上有不可逆区块高度时（TDPOS/XPoS），是否以其作为确认依据，默认使用
+	UseIrreversibleHeight bool
+	//扫描时并发预下载的区块数量
+	PrefetchBlockSize int
+	//代币合约查询余额的方法名
+	TokenBalanceMethod string
+	//合约事件写入TxOutputsExt的key，按合约地址配置，未配置的合约使用EVENT_KEY
+	ContractEventKeys map[string]string
+	//合约回执是否记录交易对合约存储的变更
+	EnableStateDiff bool
+	//存储变更的过滤前缀，格式为bucket/key，为空时记录全部变更
+	StateDiffPrefixes []string
+	//合约调用手续费为预执行gas乘以放大倍数，用于应对执行前的状态变化
+	ContractGasMultiplier decimal.Decimal
+	//合约调用的手续费上限，预执行的gas超过上限时不创建交易，0为不限制
+	ContractMaxFee decimal.Decimal
+	//合约调用的手续费下限
+	ContractMinFee decimal.Decimal
+	//代付手续费交易默认的资产账户ID，只用于CreateSponsoredSmartContractRawTransaction，其他交易由调用账户支付
+	ContractFeePayer string
+	//是否通过节点EventService订阅新区块驱动扫描，节点不支持时使用轮询
+	EnableEventSubscribe bool
+	//超过该时间没有收到区块事件视为订阅失效，恢复轮询并重新订阅，应大于出块间隔，0为不检查
+	SubscribeStaleTimeout time.Duration
+	//同一节点上的平行链名，每条链的配置在同名的section中，未配置的项使用主链配置
+	ParallelChains []string
 }
 
 func NewConfig(symbol string) *ChainConfig {
@@ -42,5 +73,58 @@ func NewConfig(symbol string) *ChainConfig {
 	c.Symbol = symbol
 	c.CurveType = CurveType
 	c.MaxTxInputs = 150
+	c.UseIrreversibleHeight = true
+	c.PrefetchBlockSize = 1
+	c.TokenBalanceMethod = "balanceOf"
+	c.ContractEventKeys = make(map[string]string)
+	c.ContractGasMultiplier = decimal.New(1, 0)
+	c.SubscribeStaleTimeout = 60 * time.Second
 	return &c
 }
+
+//EventKey 合约事件的key
+func (c *ChainConfig) EventKey(contractAddress string) string {
+	if key, ok := c.ContractEventKeys[contractAddress]; ok && len(key) > 0 {
+		return key
+	}
+	return EVENT_KEY
+}
+
+//parseContractEventKeys 解析合约事件key的配置，格式为：合约地址=key,合约地址=key
+func parseContractEventKeys(value string) map[string]string {
+	eventKeys := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+			continue
+		}
+		eventKeys[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return eventKeys
+}
+
+//parseStateDiffPrefixes 解析存储变更过滤前缀的配置，多个前缀用逗号分隔
+func parseStateDiffPrefixes(value string) []string {
+	prefixes := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		prefix := strings.TrimSpace(item)
+		if len(prefix) == 0 {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+//parseParallelChains 解析平行链配置，格式为chainA,chainB
+func parseParallelChains(value string) []string {
+	chains := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		chain := strings.TrimSpace(item)
+		if len(chain) == 0 {
+			continue
+		}
+		chains = append(chains, chain)
+	}
+	return chains
+}
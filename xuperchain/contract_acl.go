@@ -0,0 +1,227 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"regexp"
+)
+
+const (
+	METHOD_NEW_ACCOUNT     = "NewAccount"
+	METHOD_SET_ACCOUNT_ACL = "SetAccountAcl"
+	METHOD_SET_METHOD_ACL  = "SetMethodAcl"
+
+	//NewAccountABI 系统合约NewAccount方法的ABI，account_name为16位数字
+	NewAccountABI = `[{"constant":false,"inputs":[{"name":"account_name","type":"string"},{"name":"acl","type":"string"}],"name":"NewAccount","outputs":[],"payable":false,"type":"function"}]`
+
+	//SetAccountACLABI 系统合约SetAccountAcl方法的ABI，account_name为完整的合约账户
+	SetAccountACLABI = `[{"constant":false,"inputs":[{"name":"account_name","type":"string"},{"name":"acl","type":"string"}],"name":"SetAccountAcl","outputs":[],"payable":false,"type":"function"}]`
+
+	//SetMethodACLABI 系统合约SetMethodAcl方法的ABI，account_name用于查找合约账户的ACL授权
+	SetMethodACLABI = `[{"constant":false,"inputs":[{"name":"account_name","type":"string"},{"name":"contract_name","type":"string"},{"name":"method_name","type":"string"},{"name":"acl","type":"string"}],"name":"SetMethodAcl","outputs":[],"payable":false,"type":"function"}]`
+)
+
+var (
+	accountNumberRegex = regexp.MustCompile(`^[0-9]{16}$`)
+)
+
+//ACLPermissionModel ACL权限模型
+type ACLPermissionModel struct {
+	Rule        int32   `json:"rule"`        //权限规则，目前只支持签名阈值
+	AcceptValue float64 `json:"acceptValue"` //签名权重需达到的阈值
+}
+
+//AccountACL 合约账户或合约方法的ACL
+type AccountACL struct {
+	PM        ACLPermissionModel `json:"pm"`        //权限模型
+	AksWeight map[string]float64 `json:"aksWeight"` //地址的签名权重
+}
+
+//NewThresholdACL 创建签名阈值规则的ACL
+func NewThresholdACL(acceptValue float64, aksWeight map[string]float64) *AccountACL {
+	return &AccountACL{
+		PM: ACLPermissionModel{
+			Rule:        int32(pb.PermissionRule_SIGN_THRESHOLD),
+			AcceptValue: acceptValue,
+		},
+		AksWeight: aksWeight,
+	}
+}
+
+//newAccountACL 转化链上的ACL
+func newAccountACL(acl *pb.Acl) *AccountACL {
+	return &AccountACL{
+		PM: ACLPermissionModel{
+			Rule:        int32(acl.GetPm().GetRule()),
+			AcceptValue: acl.GetPm().GetAcceptValue(),
+		},
+		AksWeight: acl.GetAksWeight(),
+	}
+}
+
+//Validate 本地检查ACL的规则和权重，链上不限制阈值和权重的取值范围，签名时要求签名地址的权重之和不小于阈值
+func (acl *AccountACL) Validate(addrVerify func(address string) bool) error {
+
+	if acl.PM.Rule != int32(pb.PermissionRule_SIGN_THRESHOLD) {
+		return fmt.Errorf("acl rule: %d is not supported", acl.PM.Rule)
+	}
+
+	if len(acl.AksWeight) == 0 {
+		return fmt.Errorf("acl aks weight is empty")
+	}
+
+	totalWeight := float64(0)
+	for address, weight := range acl.AksWeight {
+		if addrVerify != nil && !addrVerify(address) {
+			return fmt.Errorf("acl address: %s is invalid", address)
+		}
+		totalWeight += weight
+	}
+
+	//所有地址签名仍达不到阈值，账户将无法使用
+	if totalWeight < acl.PM.AcceptValue {
+		return fmt.Errorf("acl total weight: %v is less than accept value: %v", totalWeight, acl.PM.AcceptValue)
+	}
+
+	return nil
+}
+
+//JSON ACL的json
+func (acl *AccountACL) JSON() (string, error) {
+	aclJSON, err := json.Marshal(acl)
+	if err != nil {
+		return "", err
+	}
+	return string(aclJSON), nil
+}
+
+//validateACL 检查ACL，地址使用链的地址规则校验
+func (decoder *ContractDecoder) validateACL(acl *AccountACL) (string, error) {
+
+	if acl == nil {
+		return "", fmt.Errorf("acl is empty")
+	}
+
+	addrVerify := func(address string) bool {
+		return decoder.wm.AddrDecoder.AddressVerify(address)
+	}
+	if err := acl.Validate(addrVerify); err != nil {
+		return "", err
+	}
+
+	return acl.JSON()
+}
+
+//QueryAccountACL 查询合约账户当前的ACL
+func (decoder *ContractDecoder) QueryAccountACL(accountName string) (*AccountACL, error) {
+
+	aclStatus, exist, err := decoder.wm.RPC.QueryACL(accountName)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("can not find account with name: %s", accountName)
+	}
+
+	return newAccountACL(aclStatus.GetAcl()), nil
+}
+
+//QueryMethodACL 查询合约方法当前的ACL
+func (decoder *ContractDecoder) QueryMethodACL(contractName, methodName string) (*AccountACL, error) {
+
+	aclStatus, exist, err := decoder.wm.RPC.QueryMethodACL(contractName, methodName)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("can not find acl of contract: %s method: %s", contractName, methodName)
+	}
+
+	return newAccountACL(aclStatus.GetAcl()), nil
+}
+
+//CreateNewAccountRawTransaction 创建合约账户的原始交易单，accountNumber为16位数字，账户为XC+accountNumber@链名
+func (decoder *ContractDecoder) CreateNewAccountRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, accountNumber string, acl *AccountACL) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if !accountNumberRegex.MatchString(accountNumber) {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "account number: %s must be 16 digits", accountNumber)
+	}
+
+	accountName := contractAccountName(accountNumber, decoder.wm.Config.ChainName)
+	if _, exist, _ := decoder.wm.RPC.QueryACL(accountName); exist {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "account: %s is already exist", accountName)
+	}
+
+	aclJSON, err := decoder.validateACL(acl)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	abiParam := []string{METHOD_NEW_ACCOUNT, accountNumber, aclJSON}
+
+	return decoder.createKernelRawTransaction(wrapper, account, NewAccountABI, abiParam)
+}
+
+//CreateSetAccountACLRawTransaction 创建修改合约账户ACL的原始交易单，需要账户当前ACL的地址签名
+func (decoder *ContractDecoder) CreateSetAccountACLRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, accountName string, acl *AccountACL) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	aclJSON, err := decoder.validateACL(acl)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	abiParam := []string{METHOD_SET_ACCOUNT_ACL, accountName, aclJSON}
+
+	return decoder.createKernelRawTransaction(wrapper, account, SetAccountACLABI, abiParam)
+}
+
+//CreateSetMethodACLRawTransaction 创建设置合约方法ACL的原始交易单，需要合约所属账户的ACL地址签名
+func (decoder *ContractDecoder) CreateSetMethodACLRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, accountName, contractName, methodName string, acl *AccountACL) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if len(methodName) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "method name is empty")
+	}
+
+	aclJSON, err := decoder.validateACL(acl)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	abiParam := []string{METHOD_SET_METHOD_ACL, accountName, contractName, methodName, aclJSON}
+
+	return decoder.createKernelRawTransaction(wrapper, account, SetMethodACLABI, abiParam)
+}
+
+//contractAccountName 完整的合约账户名
+func contractAccountName(accountNumber, chainName string) string {
+	return "XC" + accountNumber + "@" + chainName
+}
+
+//isAccountKernelMethod 需要合约账户ACL授权的系统合约方法
+func isAccountKernelMethod(req *pb.InvokeRequest) bool {
+	if req.ModuleName != MODULE_XKERNEL {
+		return false
+	}
+	switch req.MethodName {
+	case METHOD_DEPLOY, METHOD_UPGRADE, METHOD_SET_ACCOUNT_ACL, METHOD_SET_METHOD_ACL:
+		return true
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package xuperchain
 
 import (
+	"context"
 	"crypto/elliptic"
 	"encoding/base64"
 	"encoding/hex"
@@ -10,6 +11,7 @@ import (
 	"github.com/blocktree/openwallet/common"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/shopspring/decimal"
 	xupercom "github.com/xuperchain/xuper-sdk-go/common"
 	"github.com/xuperchain/xuperchain/core/crypto/account"
 	"github.com/xuperchain/xuperchain/core/crypto/utils"
@@ -18,110 +20,299 @@ import (
 	"github.com/xuperchain/xuperchain/core/utxo/txhash"
 	"math/big"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	MODULE_XKERNEL = "xkernel"
+	MODULE_WASM    = "wasm"
+	MODULE_EVM     = "evm"
 	METHOD_DEPLOY  = "Deploy"
+	METHOD_UPGRADE = "Upgrade"
 	EVENT_KEY = "com.github.blocktree.xcd.event"
+
+	EVM_ARG_INPUT        = "input"
+	EVM_ARG_JSON_ENCODED = "jsonEncoded"
 )
 
+//tokenBalanceMethods 代币协议对应的余额方法
+var tokenBalanceMethods = map[string]string{
+	"xrc20": "balanceOf",
+	"erc20": "balanceOf",
+}
+
 type ContractDecoder struct {
 	*openwallet.SmartContractDecoderBase
-	wm *WalletManager
+	wm        *WalletManager
+	feeLimits sync.Map //交易摘要对应的手续费限制，广播后删除
 }
 
+//GetTokenBalanceByAddress 预执行合约的余额方法，查询地址的代币余额
 func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.SmartContract, address ...string) ([]*openwallet.TokenBalance, error) {
 
 	tokenBalanceList := make([]*openwallet.TokenBalance, 0)
 
+	abiJSON := contract.GetABI()
+	if len(abiJSON) == 0 {
+		return nil, fmt.Errorf("abi json is empty")
+	}
+	abiInstance, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := decoder.tokenBalanceMethod(contract, abiInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	isEVM := strings.HasPrefix(contract.Address, MODULE_EVM+":")
+
+	for _, addr := range address {
+
+		//evm合约的address参数使用地址hash对应的evm地址
+		balanceOwner := addr
+		if isEVM {
+			evmAddress, convErr := decoder.wm.EVMAddress(addr)
+			if convErr != nil {
+				return nil, convErr
+			}
+			balanceOwner = evmAddress
+		}
+
+		invokeRequest, encErr := decoder.wm.EncodeInvokeRequest(abiInstance, contract.Address, method, balanceOwner)
+		if encErr != nil {
+			return nil, encErr
+		}
+
+		invokeRPCReq := &pb.InvokeRPCRequest{
+			Bcname:   decoder.wm.Config.ChainName,
+			Requests: []*pb.InvokeRequest{invokeRequest},
+		}
+
+		resp, preErr := decoder.wm.RPC.PreExecWithStatus(invokeRPCReq)
+		if preErr != nil {
+			return nil, fmt.Errorf("get token balance of address: %s failed, err: %v", addr, preErr)
+		}
+
+		result, decErr := decodeContractCallResult([]abi.ABI{abiInstance}, invokeRPCReq.Requests, resp.GetResponse())
+		if decErr != nil {
+			return nil, fmt.Errorf("decode token balance of address: %s failed, err: %v", addr, decErr)
+		}
+
+		balance, balErr := decodeTokenBalanceResult(result)
+		if balErr != nil {
+			return nil, fmt.Errorf("get token balance of address: %s failed, err: %v", addr, balErr)
+		}
+		balance = balance.Shift(-int32(contract.Decimals))
+
+		tokenBalance := &openwallet.TokenBalance{
+			Contract: &contract,
+			Balance: &openwallet.Balance{
+				Address:          addr,
+				Symbol:           contract.Symbol,
+				Balance:          balance.String(),
+				ConfirmBalance:   balance.String(),
+				UnconfirmBalance: "0",
+			},
+		}
+
+		tokenBalanceList = append(tokenBalanceList, tokenBalance)
+	}
+
 	return tokenBalanceList, nil
 }
 
+//tokenBalanceMethod 查找合约的余额方法
+//优先使用配置的方法名，其次按合约协议查找，最后查找ABI中只读的balance方法
+func (decoder *ContractDecoder) tokenBalanceMethod(contract openwallet.SmartContract, abiInstance abi.ABI) (string, error) {
+
+	if _, ok := abiInstance.Methods[decoder.wm.Config.TokenBalanceMethod]; ok {
+		return decoder.wm.Config.TokenBalanceMethod, nil
+	}
+
+	if method, ok := tokenBalanceMethods[strings.ToLower(contract.Protocol)]; ok {
+		if _, exist := abiInstance.Methods[method]; exist {
+			return method, nil
+		}
+	}
+
+	for name, method := range abiInstance.Methods {
+		if !method.Const || len(method.Inputs) != 1 || len(method.Outputs) != 1 {
+			continue
+		}
+		if strings.Contains(strings.ToLower(name), "balance") {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("contract: %s can not find balance method in abi", contract.Address)
+}
+
+//decodeTokenBalanceResult 解析余额方法的预执行结果，合约返回失败状态时返回错误
+//evm合约使用abi解码的返回值，其他合约返回数字字符串
+func decodeTokenBalanceResult(result *ContractCallResult) (decimal.Decimal, error) {
+
+	if failed := result.Failed(); failed != nil {
+		return decimal.Zero, fmt.Errorf("contract status: %d message: %s", failed.Status, failed.Message)
+	}
+
+	primary := result.Primary()
+	if primary == nil {
+		return decimal.Zero, fmt.Errorf("contract response is empty")
+	}
+
+	if strings.HasPrefix(primary.Contract, MODULE_EVM+":") {
+		fields, _ := primary.Result.(map[string]interface{})
+		if len(fields) != 1 {
+			return decimal.Zero, fmt.Errorf("balance output is invalid: %v", primary.Result)
+		}
+		for _, value := range fields {
+			return decodeTokenBalance([]byte(fmt.Sprint(value)))
+		}
+	}
+
+	body, _ := hex.DecodeString(primary.RawHex)
+	return decodeTokenBalance(body)
+}
+
+//decodeTokenBalance 解析数字字符串的余额，非数字的返回值视为错误
+func decodeTokenBalance(data []byte) (decimal.Decimal, error) {
+
+	if len(data) == 0 {
+		return decimal.Zero, nil
+	}
+
+	balance, err := decimal.NewFromString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("balance: %s is not a number", string(data))
+	}
+
+	return balance, nil
+}
+
+//appendFeePayerAuth 代付手续费的地址排在发起者之后共同签名，已在AuthRequire中时不重复添加
+func appendFeePayerAuth(invokeRPCReq *pb.InvokeRPCRequest, authAddrs []*openwallet.Address, feeAddress *openwallet.Address) []*openwallet.Address {
+	if hasAuthRequire(invokeRPCReq.AuthRequire, feeAddress.Address) {
+		return authAddrs
+	}
+	invokeRPCReq.AuthRequire = append(invokeRPCReq.AuthRequire, feeAddress.Address)
+	return append(authAddrs, feeAddress)
+}
+
 // PreInvokeContract 预执行合约
 func (decoder *ContractDecoder) PreInvokeContract(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*pb.InvokeRPCRequest, *pb.PreExecWithSelectUTXOResponse, []*openwallet.Address, *openwallet.Error) {
+	invokeRPCReq, resp, authAddrs, _, err := decoder.preInvokeContract(wrapper, rawTx, contractTxOption{})
+	return invokeRPCReq, resp, authAddrs, err
+}
+
+//contractTxOption 创建合约交易的选项
+type contractTxOption struct {
+	feePayerAccountID string                //手续费代付账户，为空时由业务账户支付
+	feeOption         *ContractFeeOption    //手续费选项，为空时使用配置的手续费限制
+	multiCalls        []*ContractInvokeCall //原子执行的多个合约调用，为空时为交易的单个调用
+}
+
+//preInvokeContract 预执行合约，option.feePayerAccountID不为空时由该账户的第一个地址提供手续费utxo并共同签名，返回手续费地址
+func (decoder *ContractDecoder) preInvokeContract(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction, option contractTxOption) (*pb.InvokeRPCRequest, *pb.PreExecWithSelectUTXOResponse, []*openwallet.Address, string, *openwallet.Error) {
 
 	var (
 		preSelUTXOReq *pb.PreExecWithSelectUTXORequest
 		authAddrs     = make([]*openwallet.Address, 0)
 	)
 	if !rawTx.Coin.IsContract {
-		return nil, nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract call msg invalid ")
+		return nil, nil, nil, "", openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract call msg invalid ")
 	}
 
-	abiJSON := rawTx.Coin.Contract.GetABI()
-	if len(abiJSON) == 0 {
-		return nil, nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "abi json is empty")
-	}
-	abiInstance, err := abi.JSON(strings.NewReader(abiJSON))
-	if err != nil {
-		return nil, nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
-	}
-
-	invokeRequest, encErr := decoder.wm.EncodeInvokeRequest(abiInstance, rawTx.Coin.Contract.Address, rawTx.ABIParam...)
+	//多个合约调用在一个交易中原子执行
+	invokes, encErr := decoder.encodeContractInvokes(rawTx, option.multiCalls)
 	if encErr != nil {
-		return nil, nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, encErr.Error())
+		return nil, nil, nil, "", encErr
 	}
 
 	// generate preExe request
-	var invokeRequests []*pb.InvokeRequest
-
-	invokeRequests = append(invokeRequests, invokeRequest)
-
 	invokeRPCReq := &pb.InvokeRPCRequest{
 		Bcname:   decoder.wm.Config.ChainName,
-		Requests: invokeRequests,
+		Requests: invokeRequests(invokes),
 	}
 
-	//系统内置的合约发布需要账户操作
-	if invokeRequest.ModuleName == MODULE_XKERNEL && invokeRequest.MethodName == METHOD_DEPLOY {
-		accountName := string(invokeRequest.Args["account_name"])
-		acl, exist, findAccErr := decoder.wm.RPC.QueryACL(accountName)
-		if findAccErr != nil {
-			return nil, nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, findAccErr.Error())
-		}
-		if !exist {
-			return nil, nil, nil, openwallet.Errorf(openwallet.ErrAccountNotFound, "can not find account with name: %s", accountName)
-		}
+	for _, invoke := range invokes {
 
-		//填充需要签名的地址
-		for addr, _ := range acl.GetAcl().GetAksWeight() {
-			invokeRPCReq.AuthRequire = append(invokeRPCReq.AuthRequire, accountName+"/"+addr)
+		invokeRequest := invoke.request
 
-			owAddress, findAddr := wrapper.GetAddress(addr)
-			if findAddr != nil {
-				return nil, nil, nil, openwallet.Errorf(openwallet.ErrAddressNotFound, "can not find address: %s", owAddress.Address)
+		//系统内置的合约发布、升级及ACL管理需要合约账户授权
+		if isAccountKernelMethod(invokeRequest) {
+			accountName := string(invokeRequest.Args["account_name"])
+
+			//升级或设置方法ACL的合约需存在于合约账户
+			if invokeRequest.MethodName == METHOD_UPGRADE || invokeRequest.MethodName == METHOD_SET_METHOD_ACL {
+				contractName := string(invokeRequest.Args["contract_name"])
+				if _, findErr := decoder.getContractCodeHash(accountName, contractName); findErr != nil {
+					return nil, nil, nil, "", findErr
+				}
 			}
 
-			authAddrs = append(authAddrs, owAddress)
+			acl, exist, findAccErr := decoder.wm.RPC.QueryACL(accountName)
+			if findAccErr != nil {
+				return nil, nil, nil, "", openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, findAccErr.Error())
+			}
+			if !exist {
+				return nil, nil, nil, "", openwallet.Errorf(openwallet.ErrAccountNotFound, "can not find account with name: %s", accountName)
+			}
+
+			//填充需要签名的地址
+			for addr, _ := range acl.GetAcl().GetAksWeight() {
+				authRequire := accountName + "/" + addr
+				if hasAuthRequire(invokeRPCReq.AuthRequire, authRequire) {
+					continue
+				}
+				invokeRPCReq.AuthRequire = append(invokeRPCReq.AuthRequire, authRequire)
+
+				owAddress, findAddr := wrapper.GetAddress(addr)
+				if findAddr != nil {
+					return nil, nil, nil, "", openwallet.Errorf(openwallet.ErrAddressNotFound, "can not find address: %s", addr)
+				}
+
+				authAddrs = append(authAddrs, owAddress)
+			}
 		}
 	}
 
 	//账户的第一个地址为默认发起者
 	defAddress, getErr := decoder.GetAssetsAccountDefAddress(wrapper, rawTx.Account.AccountID)
 	if getErr != nil {
-		return nil, nil, nil, getErr
+		return nil, nil, nil, "", getErr
 	}
 
 	invokeRPCReq.Initiator = defAddress.Address
 	invokeRPCReq.AuthRequire = append(invokeRPCReq.AuthRequire, defAddress.Address)
 	authAddrs = append(authAddrs, defAddress)
 
-	//使用地址作为发起者的utxo
+	//第三方账户代付手续费，手续费地址需共同签名
+	feeAddress := defAddress
+	if len(option.feePayerAccountID) > 0 && option.feePayerAccountID != rawTx.Account.AccountID {
+		feeAddress, getErr = decoder.GetAssetsAccountDefAddress(wrapper, option.feePayerAccountID)
+		if getErr != nil {
+			return nil, nil, nil, "", getErr
+		}
+		authAddrs = appendFeePayerAuth(invokeRPCReq, authAddrs, feeAddress)
+	}
+
+	//使用手续费地址的utxo
 	preSelUTXOReq = &pb.PreExecWithSelectUTXORequest{
 		Bcname:      decoder.wm.Config.ChainName,
-		Address:     defAddress.Address,
+		Address:     feeAddress.Address,
 		TotalAmount: 0,
 		Request:     invokeRPCReq,
 	}
 
 	resp, preErr := decoder.wm.RPC.PreExecWithSelectUTXO(preSelUTXOReq)
 	if preErr != nil {
-		return nil, nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, preErr.Error())
+		return nil, nil, nil, "", openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, preErr.Error())
 	}
 
-	return invokeRPCReq, resp, authAddrs, nil
+	return invokeRPCReq, resp, authAddrs, feeAddress.Address, nil
 }
 
 func (decoder *ContractDecoder) GetAssetsAccountDefAddress(wrapper openwallet.WalletDAI, accountID string) (*openwallet.Address, *openwallet.Error) {
@@ -140,70 +331,161 @@ func (decoder *ContractDecoder) GetAssetsAccountDefAddress(wrapper openwallet.Wa
 
 //调用合约ABI方法
 func (decoder *ContractDecoder) CallSmartContractABI(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractCallResult, *openwallet.Error) {
+	callResult, _, err := decoder.CallSmartContractABIWithResult(wrapper, rawTx)
+	return callResult, err
+}
 
-	if !rawTx.Coin.IsContract {
-		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract call msg invalid ")
-	}
+//CallSmartContractABIWithResult 调用合约ABI方法，同时返回所有请求按abi解码的结果和消耗的gas
+func (decoder *ContractDecoder) CallSmartContractABIWithResult(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractCallResult, *ContractCallResult, *openwallet.Error) {
+	return decoder.callSmartContractABI(rawTx, nil)
+}
 
-	abiJSON := rawTx.Coin.Contract.GetABI()
-	if len(abiJSON) == 0 {
-		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "abi json is empty")
+//CallMultiInvokeABI 预执行多个合约调用，调用结果的Value为最后一个调用的返回，所有调用的结果和gas另外返回
+func (decoder *ContractDecoder) CallMultiInvokeABI(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction, calls []*ContractInvokeCall) (*openwallet.SmartContractCallResult, *ContractCallResult, *openwallet.Error) {
+	if len(calls) == 0 {
+		return nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract invoke calls is empty")
 	}
-	abiInstance, err := abi.JSON(strings.NewReader(abiJSON))
-	if err != nil {
-		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	return decoder.callSmartContractABI(rawTx, calls)
+}
+
+//callSmartContractABI 预执行合约调用，multiCalls为空时为交易的单个调用
+func (decoder *ContractDecoder) callSmartContractABI(rawTx *openwallet.SmartContractRawTransaction, multiCalls []*ContractInvokeCall) (*openwallet.SmartContractCallResult, *ContractCallResult, *openwallet.Error) {
+
+	if !rawTx.Coin.IsContract {
+		return nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract call msg invalid ")
 	}
 
-	invokeRequest, encErr := decoder.wm.EncodeInvokeRequest(abiInstance, rawTx.Coin.Contract.Address, rawTx.ABIParam...)
+	invokes, encErr := decoder.encodeContractInvokes(rawTx, multiCalls)
 	if encErr != nil {
-		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, encErr.Error())
+		return nil, nil, encErr
 	}
 
-	// generate preExe request
-	var invokeRequests []*pb.InvokeRequest
-
-	invokeRequests = append(invokeRequests, invokeRequest)
-
 	invokeRPCReq := &pb.InvokeRPCRequest{
 		Bcname:   decoder.wm.Config.ChainName,
-		Requests: invokeRequests,
+		Requests: invokeRequests(invokes),
 	}
 
-	resp, preErr := decoder.wm.RPC.PreExec(invokeRPCReq)
+	//合约返回的失败状态由调用结果体现
+	resp, preErr := decoder.wm.RPC.PreExecWithStatus(invokeRPCReq)
 	if preErr != nil {
-		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, preErr.Error())
+		return nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, preErr.Error())
 	}
 
-	//gas := resp.GetResponse().GetGasUsed()
-	//fmt.Printf("gas used: %v\n", gas)
-	var rJson []byte
-	for _, res := range resp.GetResponse().GetResponse() {
-		//fmt.Printf("contract response: %s\n", string(res))
-		rJson = res
+	return newSmartContractCallResult(invokes, invokeMethod(invokes), resp.GetResponse())
+}
+
+//newSmartContractCallResult 按abi解码预执行结果，Value和RawHex为主调用的返回，所有请求的结果和gas另外返回
+func newSmartContractCallResult(invokes []*contractInvoke, method string, resp *pb.InvokeResponse) (*openwallet.SmartContractCallResult, *ContractCallResult, *openwallet.Error) {
+
+	result, decErr := decodeContractCallResult(invokeABIs(invokes), invokeRequests(invokes), resp)
+	if decErr != nil {
+		return nil, nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, decErr.Error())
 	}
 
 	callResult := &openwallet.SmartContractCallResult{
-		Method: rawTx.ABIParam[0],
+		Method: method,
+		Status: openwallet.SmartContractCallResultStatusSuccess,
 	}
 
-	callResult.RawHex = hex.EncodeToString(rJson)
-	callResult.Value = string(rJson)
-	callResult.Status = openwallet.SmartContractCallResultStatusSuccess
+	//主调用为最后一个请求
+	if primary := result.Primary(); primary != nil {
+		callResult.Value = primary.Value()
+		callResult.RawHex = primary.RawHex
+	}
 
+	if failed := result.Failed(); failed != nil {
+		callResult.Status = openwallet.SmartContractCallResultStatusFail
+		callResult.Exception = fmt.Sprintf("contract: %s method: %s status: %d message: %s", failed.Contract, failed.Method, failed.Status, failed.Message)
+	}
 
-	return callResult, nil
+	return callResult, result, nil
 }
 
-//创建原始交易单
+//创建原始交易单，手续费由调用账户支付，代付手续费使用CreateSponsoredSmartContractRawTransaction
 func (decoder *ContractDecoder) CreateSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) *openwallet.Error {
+	_, err := decoder.createSmartContractRawTransaction(wrapper, rawTx, contractTxOption{})
+	return err
+}
 
-	invokeRPCReq, preResp, authAddrs, encErr := decoder.PreInvokeContract(wrapper, rawTx)
+//CreateMultiInvokeRawTransaction 创建多个合约调用在一个交易中原子执行的原始交易单，预执行的gas合计为一笔手续费
+//扫块时每个关注的合约生成各自的回执，交易的手续费只记录在第一个回执，其余回执的手续费为0
+func (decoder *ContractDecoder) CreateMultiInvokeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction, calls []*ContractInvokeCall) *openwallet.Error {
+	if len(calls) == 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, "contract invoke calls is empty")
+	}
+	_, err := decoder.createSmartContractRawTransaction(wrapper, rawTx, contractTxOption{multiCalls: calls})
+	return err
+}
+
+//CreateSmartContractRawTransactionWithFee 按本次调用的手续费选项创建原始交易单，返回交易单实际使用的手续费限制
+func (decoder *ContractDecoder) CreateSmartContractRawTransactionWithFee(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction, option *ContractFeeOption) (*ContractFeeLimit, *openwallet.Error) {
+	return decoder.createSmartContractRawTransaction(wrapper, rawTx, contractTxOption{feeOption: option})
+}
+
+//CreateSponsoredSmartContractRawTransaction 创建由第三方账户支付手续费的原始交易单，业务账户仍为交易发起者
+//feePayerAccountID为空时使用配置的手续费代付账户
+func (decoder *ContractDecoder) CreateSponsoredSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction, feePayerAccountID string) *openwallet.Error {
+	if len(feePayerAccountID) == 0 {
+		feePayerAccountID = decoder.wm.Config.ContractFeePayer
+	}
+	if len(feePayerAccountID) == 0 {
+		return openwallet.Errorf(openwallet.ErrAccountNotFound, "fee payer account is empty")
+	}
+	_, err := decoder.createSmartContractRawTransaction(wrapper, rawTx, contractTxOption{feePayerAccountID: feePayerAccountID})
+	return err
+}
+
+//createSmartContractRawTransaction 按选项创建原始交易单，返回交易单实际使用的手续费限制
+func (decoder *ContractDecoder) createSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction, option contractTxOption) (*ContractFeeLimit, *openwallet.Error) {
+
+	//手续费按预执行的gas放大，并受上下限约束
+	feeLimit, limitErr := newContractFeeLimit(decoder.wm.Config, option.feeOption, decoder.wm.Decimal())
+	if limitErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, limitErr.Error())
+	}
+
+	invokeRPCReq, preResp, authAddrs, feeAddress, encErr := decoder.preInvokeContract(wrapper, rawTx, option)
 	if encErr != nil {
-		return encErr
+		return nil, encErr
+	}
+
+	gasUsed := preResp.GetResponse().GetGasUsed()
+	amount, feeErr := feeLimit.Fee(gasUsed)
+	if feeErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, feeErr.Error())
+	}
+
+	utxoList, total, selErr := decoder.selectFeeUTXO(feeAddress, amount, preResp)
+	if selErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, selErr.Error())
+	}
+
+	tx := newContractTransaction(invokeRPCReq, preResp, utxoList, total, amount, feeAddress)
+
+	if signErr := decoder.appendRawTxSignatures(rawTx, tx, authAddrs); signErr != nil {
+		return nil, signErr
+	}
+
+	txJSON, _ := json.Marshal(tx)
+	rawTx.Raw = string(txJSON)
+	rawTx.RawType = openwallet.TxRawTypeJSON
+	rawTx.Fees = common.BigIntToDecimals(amount, decoder.wm.Decimal()).String()
+	rawTx.IsBuilt = true
+	rawTx.TxFrom = invokeRPCReq.Initiator
+	rawTx.TxTo = rawTx.Coin.Contract.Address
+	if option.multiCalls != nil {
+		rawTx.TxTo = contractAddressesOfRequests(tx.ContractRequests)
 	}
 
-	//手续费
-	amount := big.NewInt(preResp.Response.GasUsed)
+	//记录交易单的手续费限制，通过GetContractFeeLimit获取
+	result := feeLimit.result(gasUsed, amount, decoder.wm.Decimal())
+	decoder.feeLimits.Store(rawTxDigest(rawTx), result)
+
+	return result, nil
+}
+
+//newContractTransaction 按预执行结果构造合约交易，手续费utxo来自feeAddress，找零也返回feeAddress
+func newContractTransaction(invokeRPCReq *pb.InvokeRPCRequest, preResp *pb.PreExecWithSelectUTXOResponse, utxoList []*pb.Utxo, total, amount *big.Int, feeAddress string) *pb.Transaction {
 
 	// 构造一个发起的交易
 	tx := &pb.Transaction{
@@ -216,12 +498,12 @@ func (decoder *ContractDecoder) CreateSmartContractRawTransaction(wrapper openwa
 	}
 	// 填充支付的手续费，手续费需要“转账”给地址“$”
 	fee := &pb.TxOutput{
-		ToAddr: []byte("$"),
+		ToAddr: []byte(FeeAddress),
 		Amount: amount.Bytes(),
 	}
 	tx.TxOutputs = append(tx.TxOutputs, fee)
 	// 填充select出来的utxo
-	for _, utxo := range preResp.UtxoOutput.UtxoList {
+	for _, utxo := range utxoList {
 		txin := &pb.TxInput{
 			RefTxid:   utxo.RefTxid,
 			RefOffset: utxo.RefOffset,
@@ -231,11 +513,10 @@ func (decoder *ContractDecoder) CreateSmartContractRawTransaction(wrapper openwa
 		tx.TxInputs = append(tx.TxInputs, txin)
 	}
 	// 处理找零的逻辑
-	total, _ := big.NewInt(0).SetString(preResp.UtxoOutput.TotalSelected, 10)
 	if total.Cmp(amount) > 0 {
-		delta := total.Sub(total, amount)
+		delta := new(big.Int).Sub(total, amount)
 		charge := &pb.TxOutput{
-			ToAddr: []byte(invokeRPCReq.Initiator),
+			ToAddr: []byte(feeAddress),
 			Amount: delta.Bytes(),
 		}
 		tx.TxOutputs = append(tx.TxOutputs, charge)
@@ -246,6 +527,12 @@ func (decoder *ContractDecoder) CreateSmartContractRawTransaction(wrapper openwa
 	tx.TxOutputsExt = preResp.GetResponse().GetOutputs()
 	tx.AuthRequire = invokeRPCReq.AuthRequire
 
+	return tx
+}
+
+//appendRawTxSignatures 按交易摘要为授权地址创建待签名的KeySignature
+func (decoder *ContractDecoder) appendRawTxSignatures(rawTx *openwallet.SmartContractRawTransaction, tx *pb.Transaction, authAddrs []*openwallet.Address) *openwallet.Error {
+
 	digestHash, dhErr := txhash.MakeTxDigestHash(tx)
 	if dhErr != nil {
 		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, dhErr.Error())
@@ -274,19 +561,16 @@ func (decoder *ContractDecoder) CreateSmartContractRawTransaction(wrapper openwa
 		rawTx.Signatures[address.AccountID] = keySigs
 	}
 
-	txJSON, _ := json.Marshal(tx)
-	rawTx.Raw = string(txJSON)
-	rawTx.RawType = openwallet.TxRawTypeJSON
-	rawTx.Fees = common.BigIntToDecimals(amount, decoder.wm.Decimal()).String()
-	rawTx.IsBuilt = true
-	rawTx.TxFrom = invokeRPCReq.Initiator
-	rawTx.TxTo = rawTx.Coin.Contract.Address
-
 	return nil
 }
 
 //SubmitRawTransaction 广播交易单
 func (decoder *ContractDecoder) SubmitSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractReceipt, *openwallet.Error) {
+	return decoder.SubmitSmartContractRawTransactionWithContext(context.Background(), wrapper, rawTx)
+}
+
+//SubmitSmartContractRawTransactionWithContext 广播交易单，等待回执时可通过ctx取消，超时返回状态为pending的回执
+func (decoder *ContractDecoder) SubmitSmartContractRawTransactionWithContext(ctx context.Context, wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractReceipt, *openwallet.Error) {
 
 	nTx, err := decoder.VerifyRawTransaction(wrapper, rawTx)
 
@@ -303,6 +587,7 @@ func (decoder *ContractDecoder) SubmitSmartContractRawTransaction(wrapper openwa
 
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
+	decoder.feeLimits.Delete(rawTxDigest(rawTx))
 
 	owtx := &openwallet.SmartContractReceipt{
 		Coin:  rawTx.Coin,
@@ -318,72 +603,24 @@ func (decoder *ContractDecoder) SubmitSmartContractRawTransaction(wrapper openwa
 	decoder.wm.Log.Infof("rawTx.AwaitResult = %v", rawTx.AwaitResult)
 	//等待出块结果返回交易回执
 	if rawTx.AwaitResult {
-		bs := decoder.wm.GetBlockScanner()
-		if bs == nil {
-			decoder.wm.Log.Errorf("adapter blockscanner is nil")
-			return owtx, nil
-		}
-
-		addrs := make(map[string]openwallet.ScanTargetResult)
 		contract := &rawTx.Coin.Contract
-		if contract == nil {
-			decoder.wm.Log.Errorf("rawTx.Coin.Contract is nil")
-			return owtx, nil
-		}
-
-		addrs[contract.Address] = openwallet.ScanTargetResult{SourceKey: contract.ContractID, Exist: true, TargetInfo: contract}
-
-		scanTargetFunc := func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
-			result := addrs[target.ScanTarget]
-			if result.Exist {
-				return result
-			}
-			return openwallet.ScanTargetResult{SourceKey: "", Exist: false, TargetInfo: nil}
-		}
 
 		//默认超时90秒
 		if rawTx.AwaitTimeout == 0 {
 			rawTx.AwaitTimeout = 90
 		}
 
-		sleepSecond := 2 * time.Second
-
-		//计算过期时间
-		currentServerTime := time.Now()
-		expiredTime := currentServerTime.Add(time.Duration(rawTx.AwaitTimeout) * time.Second)
-
-		//等待交易单报块结果
-		for {
-
-			//当前重试时间
-			currentReDoTime := time.Now()
-
-			//decoder.wm.Log.Debugf("currentReDoTime = %s", currentReDoTime.String())
-			//decoder.wm.Log.Debugf("expiredTime = %s", expiredTime.String())
-
-			//超时终止
-			if currentReDoTime.Unix() >= expiredTime.Unix() {
-				break
-			}
-
-			_, contractResult, extractErr := bs.ExtractTransactionAndReceiptData(owtx.TxID, scanTargetFunc)
-			if extractErr != nil {
-				continue
-				//decoder.wm.Log.Errorf("ExtractTransactionAndReceiptData failed, err: %v", extractErr)
-				//return owtx, nil
-			}
-
-			//tx := txResult[contract.ContractID]
-			receipt := contractResult[contract.ContractID]
-
-			if receipt != nil {
-				return receipt, nil
+		receipt, waitErr := decoder.wm.ReceiptWaiter.Wait(ctx, time.Duration(rawTx.AwaitTimeout)*time.Second, owtx, contract)
+		if waitErr != nil {
+			//交易已提交，取消等待返回状态为pending并记录取消原因的回执
+			decoder.wm.Log.Infof("wait transaction: %s receipt canceled, err: %v", owtx.TxID, waitErr)
+			if receipt == nil {
+				receipt = pendingReceiptOf(owtx, waitErr.Error())
 			}
-
-			//等待sleepSecond秒重试
-			time.Sleep(sleepSecond)
+			return receipt, nil
 		}
 
+		return receipt, nil
 	}
 
 	return owtx, nil
@@ -421,6 +658,9 @@ func (decoder *ContractDecoder) VerifyRawTransaction(wrapper openwallet.WalletDA
 		return nil, err
 	}
 
+	//签名需按AuthRequire的顺序填充，代付手续费的签名来自不同账户
+	addressSigns := make(map[string]*pb.SignatureInfo)
+
 	for accountID, keySignatures := range rawTx.Signatures {
 		decoder.wm.Log.Debug("accountID Signatures:", accountID)
 		for _, keySignature := range keySignatures {
@@ -460,14 +700,23 @@ func (decoder *ContractDecoder) VerifyRawTransaction(wrapper openwallet.WalletDA
 				tx.InitiatorSigns = append(tx.InitiatorSigns, signInfo)
 			}
 
-			// 将签名填充进交易
-			tx.AuthRequireSigns = append(tx.AuthRequireSigns, signInfo)
+			addressSigns[keySignature.Address.Address] = signInfo
 
 			decoder.wm.Log.Debug("Signature:", keySignature.Signature)
 			decoder.wm.Log.Debug("PublicKey:", keySignature.Address.PublicKey)
 		}
 	}
 
+	// 将签名填充进交易，AuthRequire的格式为地址或合约账户/地址
+	for _, authRequire := range tx.AuthRequire {
+		addr := authRequire[strings.LastIndex(authRequire, "/")+1:]
+		signInfo, ok := addressSigns[addr]
+		if !ok {
+			return nil, fmt.Errorf("transaction auth require: %s signature is missing", authRequire)
+		}
+		tx.AuthRequireSigns = append(tx.AuthRequireSigns, signInfo)
+	}
+
 	txJSON, _ := json.Marshal(tx)
 	rawTx.Raw = string(txJSON)
 	rawTx.IsCompleted = true
@@ -0,0 +1,558 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/shopspring/decimal"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContractDecoder_GetTokenBalanceByAddress(t *testing.T) {
+	contract := openwallet.SmartContract{
+		Address:  "wasm:artToyContract2",
+		Symbol:   "XUPER",
+		Protocol: "xrc20",
+		Decimals: 8,
+	}
+	contract.SetABI(`[{"constant":true,"inputs":[{"internalType":"address","name":"address","type":"address"}],"name":"balanceOf","outputs":[{"internalType":"uint256","name":"balance","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"}]`)
+
+	balances, err := tw.ContractDecoder.GetTokenBalanceByAddress(contract, "Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb")
+	if err != nil {
+		t.Errorf("GetTokenBalanceByAddress failed, err: %v", err)
+		return
+	}
+	if len(balances) != 1 {
+		t.Errorf("unexpected balances count: %d", len(balances))
+		return
+	}
+	if _, decErr := decimal.NewFromString(balances[0].Balance.Balance); decErr != nil {
+		t.Errorf("balance is not a number: %s", balances[0].Balance.Balance)
+		return
+	}
+	log.Infof("balance: %+v", balances[0].Balance)
+}
+
+func TestDecodeTokenBalanceResult(t *testing.T) {
+	abiJSON := `[{"constant":true,"inputs":[{"name":"address","type":"address"}],"name":"balanceOf","outputs":[{"name":"balance","type":"uint256"}],"type":"function"}]`
+	abiInstance, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		t.Errorf("abi.JSON failed, err: %v", err)
+		return
+	}
+
+	evmBody, _ := abiInstance.Methods["balanceOf"].Outputs.Pack(big.NewInt(12345))
+
+	tests := []struct {
+		module string
+		resp   *pb.ContractResponse
+		want   string
+		fail   bool
+	}{
+		{module: "wasm", resp: &pb.ContractResponse{Status: 200, Body: []byte("1000")}, want: "1000"},
+		{module: "wasm", resp: &pb.ContractResponse{Status: 200}, want: "0"},
+		{module: "wasm", resp: &pb.ContractResponse{Status: 200, Body: []byte("account not found")}, fail: true},
+		{module: "wasm", resp: &pb.ContractResponse{Status: 500, Message: "method not found"}, fail: true},
+		{module: "evm", resp: &pb.ContractResponse{Status: 200, Body: evmBody}, want: "12345"},
+	}
+
+	for i, test := range tests {
+		requests := []*pb.InvokeRequest{{ModuleName: test.module, ContractName: "token", MethodName: "balanceOf"}}
+		result, balErr := decodeContractCallResult([]abi.ABI{abiInstance}, requests, &pb.InvokeResponse{Responses: []*pb.ContractResponse{test.resp}})
+		balance := decimal.Zero
+		if balErr == nil {
+			balance, balErr = decodeTokenBalanceResult(result)
+		}
+		if test.fail {
+			if balErr == nil {
+				t.Errorf("case %d: expected error, got balance: %s", i, balance.String())
+			}
+			continue
+		}
+		if balErr != nil {
+			t.Errorf("case %d: decodeTokenBalanceResult failed, err: %v", i, balErr)
+			continue
+		}
+		if balance.String() != test.want {
+			t.Errorf("case %d: balance = %s, want %s", i, balance.String(), test.want)
+		}
+	}
+}
+
+func TestWalletManager_EVMAddress(t *testing.T) {
+	evmAddress, err := tw.EVMAddress("nofJPPzVCpDnXixVhLWfEeyzgDDAu9rSo")
+	if err != nil {
+		t.Errorf("EVMAddress failed, err: %v", err)
+		return
+	}
+	if strings.ToLower(evmAddress) != "0xf670c3fa9d6ba96157e1ada7413f3f83a1d2e2a9" {
+		t.Errorf("unexpected evm address: %s", evmAddress)
+	}
+
+	if _, err = tw.EVMAddress("not-an-address"); err == nil {
+		t.Errorf("EVMAddress of invalid address should fail")
+	}
+}
+
+func TestDecodeContractCallResult(t *testing.T) {
+	abiInstance, err := abi.JSON(strings.NewReader(`[{"constant":true,"inputs":[{"name":"address","type":"address"}],"name":"balanceOf","outputs":[{"name":"balance","type":"uint256"}],"type":"function"}]`))
+	if err != nil {
+		t.Errorf("abi.JSON failed, err: %v", err)
+		return
+	}
+	requests := []*pb.InvokeRequest{
+		{ModuleName: "wasm", ContractName: "artToyContract2", MethodName: "balanceOf"},
+		{ModuleName: "wasm", ContractName: "artToyContract2", MethodName: "balanceOf"},
+	}
+	resp := &pb.InvokeResponse{
+		GasUsed: 100,
+		Responses: []*pb.ContractResponse{
+			{Status: 200, Body: []byte("1000")},
+			{Status: 500, Message: "account not found"},
+		},
+	}
+	result, err := decodeContractCallResult([]abi.ABI{abiInstance, abiInstance}, requests, resp)
+	if err != nil {
+		t.Errorf("decodeContractCallResult failed, err: %v", err)
+		return
+	}
+	if result.Responses[0].Result.(map[string]interface{})["balance"] != "1000" {
+		t.Errorf("balance decode failed: %+v", result.Responses[0].Result)
+		return
+	}
+	if failed := result.Failed(); failed == nil || failed.Status != 500 {
+		t.Errorf("failed response is not found")
+		return
+	}
+	log.Infof("gas used: %d", result.GasUsed)
+}
+
+func TestContractInvokeCalls(t *testing.T) {
+	multiCalls := []*ContractInvokeCall{
+		{Address: "wasm:artToyContract2", ABIParam: []string{"transfer", "Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb", "100"}},
+		{ABIParam: []string{"transfer", "Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb", "200"}},
+	}
+
+	//合约方法名为multiInvoke时按普通调用处理
+	rawTx := &openwallet.SmartContractRawTransaction{ABIParam: []string{"multiInvoke", "[]"}}
+	rawTx.Coin.Contract.Address = "wasm:artToyContract3"
+	rawTx.Coin.Contract.SetABI(`[{"inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"name":"transfer","outputs":[],"type":"function"}]`)
+
+	calls, err := contractInvokeCalls(rawTx, nil)
+	if err != nil {
+		t.Errorf("contractInvokeCalls failed, err: %v", err)
+		return
+	}
+	if len(calls) != 1 || calls[0].ABIParam[0] != "multiInvoke" || calls[0].Address != "wasm:artToyContract3" {
+		t.Errorf("single invoke call is invalid: %+v", calls)
+		return
+	}
+
+	calls, err = contractInvokeCalls(rawTx, multiCalls)
+	if err != nil {
+		t.Errorf("contractInvokeCalls failed, err: %v", err)
+		return
+	}
+	if len(calls) != 2 || calls[0].Address != "wasm:artToyContract2" || calls[1].Address != "wasm:artToyContract3" || len(calls[1].ABI) == 0 {
+		t.Errorf("contract invoke calls is invalid: %+v", calls)
+		return
+	}
+	//不修改调用方的调用列表
+	if len(multiCalls[1].Address) != 0 {
+		t.Errorf("multi calls should not be modified")
+		return
+	}
+
+	if _, err = contractInvokeCalls(rawTx, []*ContractInvokeCall{}); err == nil {
+		t.Errorf("empty multi calls should fail")
+	}
+}
+
+func TestNewWasmDeployABIParam(t *testing.T) {
+	param := &WasmDeployParam{
+		AccountName:  "XC3333333333333333@xuper",
+		ContractName: "artToyContract2",
+		Code:         []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+		Runtime:      WASM_RUNTIME_GO,
+		InitArgs:     map[string]string{"initSupply": "20000000000"},
+	}
+	abiParam, err := NewWasmDeployABIParam(param)
+	if err != nil {
+		t.Errorf("NewWasmDeployABIParam failed, err: %v", err)
+		return
+	}
+	log.Infof("init args: %s", abiParam[5])
+
+	param.Runtime = "java"
+	if _, err = NewWasmDeployABIParam(param); err == nil {
+		t.Errorf("NewWasmDeployABIParam should failed with invalid runtime")
+		return
+	}
+}
+
+func TestNewWasmUpgradeABIParam(t *testing.T) {
+	param := &WasmUpgradeParam{
+		AccountName:  "XC3333333333333333@xuper",
+		ContractName: "artToyContract2",
+		Code:         []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+	}
+	abiParam, err := NewWasmUpgradeABIParam(param)
+	if err != nil {
+		t.Errorf("NewWasmUpgradeABIParam failed, err: %v", err)
+		return
+	}
+	log.Infof("upgrade abi param: %v, new code hash: %s", abiParam[:3], wasmCodeHash(param.Code))
+}
+
+func TestAccountACL_Validate(t *testing.T) {
+	acl := NewThresholdACL(1, map[string]float64{
+		"ahsTENdPBruBtjjJF53ioHAx1yk2HhjnU": 0.5,
+		"Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb": 0.5,
+	})
+	aclJSON, err := tw.ContractDecoder.validateACL(acl)
+	if err != nil {
+		t.Errorf("validateACL failed, err: %v", err)
+		return
+	}
+	log.Infof("acl: %s", aclJSON)
+
+	acl.AksWeight["Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb"] = 0.4
+	if _, err = tw.ContractDecoder.validateACL(acl); err == nil {
+		t.Errorf("validateACL should failed with total weight less than accept value")
+		return
+	}
+
+	//链上允许大于1的阈值和权重
+	acl = NewThresholdACL(2, map[string]float64{
+		"ahsTENdPBruBtjjJF53ioHAx1yk2HhjnU": 1,
+		"Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb": 1.5,
+	})
+	if _, err = tw.ContractDecoder.validateACL(acl); err != nil {
+		t.Errorf("validateACL failed, err: %v", err)
+		return
+	}
+}
+
+func TestContractDecoder_QueryAccountACL(t *testing.T) {
+	acl, err := tw.ContractDecoder.QueryAccountACL("XC3333333333333333@xuper")
+	if err != nil {
+		t.Errorf("QueryAccountACL failed, err: %v", err)
+		return
+	}
+	log.Infof("acl: %+v", acl)
+}
+
+func TestVoteCandidates(t *testing.T) {
+	addrVerify := tw.AddrDecoder.AddressVerify
+
+	candidates, err := voteCandidates([]string{
+		"Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb",
+		"ahsTENdPBruBtjjJF53ioHAx1yk2HhjnU",
+		"Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb",
+	}, addrVerify)
+	if err != nil || len(candidates) != 2 || candidates[1] != "ahsTENdPBruBtjjJF53ioHAx1yk2HhjnU" {
+		t.Errorf("unexpected candidates: %v, err: %v", candidates, err)
+		return
+	}
+
+	if _, err = voteCandidates([]string{"Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb", "abc"}, addrVerify); err == nil {
+		t.Errorf("invalid candidate should fail")
+		return
+	}
+
+	if _, err = voteCandidates(nil, addrVerify); err == nil {
+		t.Errorf("empty candidates should fail")
+	}
+}
+
+func TestContractFeeLimit_Fee(t *testing.T) {
+	config := NewConfig(Symbol)
+	config.ContractMaxFee = decimal.RequireFromString("0.00001")
+	config.ContractMinFee = decimal.RequireFromString("0.000001")
+
+	limit, err := newContractFeeLimit(config, &ContractFeeOption{GasMultiplier: "1.5"}, 8)
+	if err != nil {
+		t.Errorf("newContractFeeLimit failed, err: %v", err)
+		return
+	}
+
+	cases := []struct {
+		gasUsed int64
+		fee     string
+	}{
+		{gasUsed: 10, fee: "100"},    //不足下限
+		{gasUsed: 101, fee: "152"},   //放大后向上取整
+		{gasUsed: 900, fee: "1000"},  //放大后不超过上限
+		{gasUsed: 1000, fee: "1000"}, //等于上限
+	}
+	for _, c := range cases {
+		fee, feeErr := limit.Fee(c.gasUsed)
+		if feeErr != nil || fee.String() != c.fee {
+			t.Errorf("gas used: %d fee: %v, err: %v", c.gasUsed, fee, feeErr)
+			return
+		}
+	}
+
+	if _, err = limit.Fee(1001); err == nil {
+		t.Errorf("gas used exceeds max fee should fail")
+		return
+	}
+
+	if _, err = newContractFeeLimit(config, &ContractFeeOption{GasMultiplier: "0.5"}, 8); err == nil {
+		t.Errorf("gas multiplier less than 1 should fail")
+		return
+	}
+
+	//未设置的项使用配置的值，设置的项覆盖配置
+	limit, err = newContractFeeLimit(config, &ContractFeeOption{MaxFee: "0.00002"}, 8)
+	if err != nil {
+		t.Errorf("newContractFeeLimit failed, err: %v", err)
+		return
+	}
+	result := limit.result(1200, big.NewInt(1200), 8)
+	if result.GasMultiplier != "1" || result.MaxFee != "0.00002" || result.MinFee != "0.000001" || result.Fee != "0.000012" {
+		t.Errorf("unexpected fee limit: %+v", result)
+		return
+	}
+
+	if _, err = newContractFeeLimit(config, &ContractFeeOption{MinFee: "abc"}, 8); err == nil {
+		t.Errorf("invalid min fee should fail")
+		return
+	}
+
+	//下限超过上限时不使用下限覆盖上限
+	if _, err = newContractFeeLimit(config, &ContractFeeOption{MinFee: "0.00002"}, 8); err == nil {
+		t.Errorf("min fee greater than max fee should fail")
+		return
+	}
+}
+
+func TestProposalDesc_Validate(t *testing.T) {
+	trigger := &ProposalTrigger{
+		Height: 200,
+		Module: "consensus",
+		Method: "update_consensus",
+		Args:   map[string]interface{}{"name": "tdpos"},
+	}
+
+	desc := NewProposalDesc(51, 150, trigger)
+	if err := desc.Validate(100); err != nil {
+		t.Errorf("Validate failed, err: %v", err)
+		return
+	}
+
+	if err := desc.Validate(150); err == nil {
+		t.Errorf("stop vote height not greater than current height should fail")
+		return
+	}
+
+	desc = NewProposalDesc(51, 150, &ProposalTrigger{Height: 150, Module: "consensus", Method: "update_consensus"})
+	if err := desc.Validate(100); err == nil {
+		t.Errorf("trigger height not greater than stop vote height should fail")
+		return
+	}
+
+	desc = NewProposalDesc(0, 150, trigger)
+	if err := desc.Validate(100); err == nil {
+		t.Errorf("min vote percent 0 should fail")
+		return
+	}
+}
+
+func TestReceiptWaiter_Wait(t *testing.T) {
+	wm := NewWalletManager()
+	contract := &openwallet.SmartContract{ContractID: "test", Address: "wasm:test"}
+	receipt := &openwallet.SmartContractReceipt{TxID: "abc"}
+
+	//超时返回pending回执
+	result, err := wm.ReceiptWaiter.Wait(context.Background(), 50*time.Millisecond, receipt, contract)
+	if err != nil {
+		t.Errorf("Wait failed, err: %v", err)
+		return
+	}
+	if result.Status != TxStatusPending || result.TxID != receipt.TxID {
+		t.Errorf("unexpected receipt status: %s", result.Status)
+	}
+	if len(receipt.Status) > 0 {
+		t.Errorf("submitted receipt should not be modified")
+	}
+
+	//取消等待
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = wm.ReceiptWaiter.Wait(ctx, time.Minute, receipt, contract)
+	if err != context.Canceled {
+		t.Errorf("Wait should be canceled, err: %v", err)
+		return
+	}
+	if result == nil || result.Status != TxStatusPending || !strings.Contains(result.Reason, "canceled") {
+		t.Errorf("canceled receipt should be pending with reason: %+v", result)
+	}
+}
+
+func TestDescTxReceipt(t *testing.T) {
+	submitted := &openwallet.SmartContractReceipt{TxID: "9a3f", To: ProposalContractAddress}
+	trx := &pb.Transaction{Txid: []byte{0x9a, 0x3f}, Desc: []byte(`{"module":"proposal","method":"Vote"}`)}
+
+	//未出块的交易继续等待
+	if receipt := descTxReceipt(submitted, &pb.TxStatus{Status: pb.TransactionStatus_UNCONFIRM, Tx: trx}, nil); receipt != nil {
+		t.Errorf("unconfirmed transaction should not have receipt")
+	}
+
+	block := &pb.InternalBlock{Blockid: []byte{0x01}, Height: 100, Timestamp: 1600000000000000000}
+	receipt := descTxReceipt(submitted, &pb.TxStatus{Status: pb.TransactionStatus_CONFIRM, Tx: trx}, block)
+	if receipt == nil || receipt.BlockHeight != 100 || receipt.BlockHash != "01" || receipt.Status != openwallet.TxStatusSuccess || receipt.ConfirmTime != 1600000000 {
+		t.Errorf("unexpected confirmed receipt: %+v", receipt)
+	}
+	if submitted.BlockHeight != 0 {
+		t.Errorf("submitted receipt should not be modified")
+	}
+
+	receipt = descTxReceipt(submitted, &pb.TxStatus{Status: pb.TransactionStatus_FURCATION, Tx: trx}, nil)
+	if receipt == nil || receipt.Status != openwallet.TxStatusFail {
+		t.Errorf("furcation transaction should fail: %+v", receipt)
+	}
+
+	if !isDescContractAddress(ProposalContractAddress) || !isDescContractAddress(TDPOSContractAddress) || isDescContractAddress("wasm:test") {
+		t.Errorf("isDescContractAddress is invalid")
+	}
+}
+
+//testSignAddress 测试用的签名地址，私钥为固定值
+func testSignAddress(accountID, address string, seed byte) (*openwallet.Address, []byte) {
+	priv := make([]byte, 32)
+	for i := range priv {
+		priv[i] = seed + byte(i)
+	}
+	pub := owcrypt.GenPubkey(priv, owcrypt.ECC_CURVE_NIST_P256)
+	return &openwallet.Address{
+		AccountID: accountID,
+		Address:   address,
+		PublicKey: hex.EncodeToString(owcrypt.PointCompress(pub, owcrypt.ECC_CURVE_NIST_P256)),
+	}, priv
+}
+
+func TestContractDecoder_SponsoredTransaction(t *testing.T) {
+	initiator, initiatorKey := testSignAddress("business", "nofJPPzVCpDnXixVhLWfEeyzgDDAu9rSo", 1)
+	feePayer, feePayerKey := testSignAddress("sponsor", "Rvm1AE6rZwLpPFbcBfD7wZxXK3FR6QEXb", 50)
+
+	//发起者在前，代付手续费的地址在后共同签名
+	invokeRPCReq := &pb.InvokeRPCRequest{
+		Initiator:   initiator.Address,
+		AuthRequire: []string{"XC1111111111111111@xuper/" + initiator.Address, initiator.Address},
+	}
+	authAddrs := []*openwallet.Address{initiator}
+	authAddrs = appendFeePayerAuth(invokeRPCReq, authAddrs, feePayer)
+	authAddrs = appendFeePayerAuth(invokeRPCReq, authAddrs, feePayer)
+	if len(invokeRPCReq.AuthRequire) != 3 || invokeRPCReq.AuthRequire[2] != feePayer.Address || len(authAddrs) != 2 || authAddrs[1] != feePayer {
+		t.Errorf("unexpected auth require: %v", invokeRPCReq.AuthRequire)
+		return
+	}
+
+	//手续费utxo来自代付地址，找零返回代付地址
+	utxoList := []*pb.Utxo{{RefTxid: []byte{0x01}, ToAddr: []byte(feePayer.Address), Amount: big.NewInt(1000).Bytes()}}
+	preResp := &pb.PreExecWithSelectUTXOResponse{Response: &pb.InvokeResponse{GasUsed: 100}}
+	tx := newContractTransaction(invokeRPCReq, preResp, utxoList, big.NewInt(1000), big.NewInt(150), feePayer.Address)
+	if len(tx.TxOutputs) != 2 || string(tx.TxOutputs[0].ToAddr) != FeeAddress || new(big.Int).SetBytes(tx.TxOutputs[0].Amount).Int64() != 150 {
+		t.Errorf("unexpected fee output: %+v", tx.TxOutputs)
+		return
+	}
+	if string(tx.TxOutputs[1].ToAddr) != feePayer.Address || new(big.Int).SetBytes(tx.TxOutputs[1].Amount).Int64() != 850 {
+		t.Errorf("change should go to fee payer: %+v", tx.TxOutputs[1])
+		return
+	}
+	if tx.Initiator != initiator.Address || string(tx.TxInputs[0].FromAddr) != feePayer.Address {
+		t.Errorf("unexpected initiator: %s or input: %s", tx.Initiator, string(tx.TxInputs[0].FromAddr))
+		return
+	}
+
+	rawTx := &openwallet.SmartContractRawTransaction{RawType: openwallet.TxRawTypeJSON}
+	if err := tw.ContractDecoder.appendRawTxSignatures(rawTx, tx, authAddrs); err != nil {
+		t.Errorf("appendRawTxSignatures failed, err: %v", err)
+		return
+	}
+	txJSON, _ := json.Marshal(tx)
+	rawTx.Raw = string(txJSON)
+
+	sign := func(accountID string, key []byte) {
+		for _, keySignature := range rawTx.Signatures[accountID] {
+			msg, _ := hex.DecodeString(keySignature.Message)
+			signature, _, _ := owcrypt.Signature(key, nil, msg, owcrypt.ECC_CURVE_NIST_P256)
+			keySignature.Signature = hex.EncodeToString(signature)
+		}
+	}
+
+	//缺少代付地址的签名时验证失败
+	sign(initiator.AccountID, initiatorKey)
+	feePayerSigs := rawTx.Signatures[feePayer.AccountID]
+	delete(rawTx.Signatures, feePayer.AccountID)
+	if _, err := tw.ContractDecoder.VerifyRawTransaction(nil, rawTx); err == nil {
+		t.Errorf("VerifyRawTransaction should fail without fee payer signature")
+		return
+	}
+
+	rawTx.Signatures[feePayer.AccountID] = feePayerSigs
+	sign(feePayer.AccountID, feePayerKey)
+	signedTx, err := tw.ContractDecoder.VerifyRawTransaction(nil, rawTx)
+	if err != nil {
+		t.Errorf("VerifyRawTransaction failed, err: %v", err)
+		return
+	}
+	if len(signedTx.AuthRequireSigns) != 3 || len(signedTx.InitiatorSigns) != 1 {
+		t.Errorf("unexpected signatures: auth %d, initiator %d", len(signedTx.AuthRequireSigns), len(signedTx.InitiatorSigns))
+	}
+}
+
+func TestNewSmartContractCallResult_Value(t *testing.T) {
+	abiJSON := `[{"constant":true,"inputs":[{"name":"address","type":"address"}],"name":"balanceOf","outputs":[{"name":"balance","type":"uint256"}],"type":"function"}]`
+	abiInstance, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		t.Errorf("abi.JSON failed, err: %v", err)
+		return
+	}
+
+	invokes := []*contractInvoke{
+		{abiInstance: abiInstance, request: &pb.InvokeRequest{ModuleName: "wasm", ContractName: "artToyContract2", MethodName: "balanceOf"}},
+	}
+	resp := &pb.InvokeResponse{
+		GasUsed:   100,
+		Responses: []*pb.ContractResponse{{Status: 200, Body: []byte("1000")}},
+	}
+
+	callResult, result, callErr := newSmartContractCallResult(invokes, "balanceOf", resp)
+	if callErr != nil {
+		t.Errorf("newSmartContractCallResult failed, err: %v", callErr)
+		return
+	}
+
+	//Value保持为方法的返回值，gas在详细结果中
+	if callResult.Value != "1000" || callResult.Status != openwallet.SmartContractCallResultStatusSuccess {
+		t.Errorf("unexpected call result value: %s", callResult.Value)
+	}
+	if result.GasUsed != 100 {
+		t.Errorf("unexpected gas used: %d", result.GasUsed)
+	}
+}
@@ -0,0 +1,328 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/golang/protobuf/proto"
+	"github.com/xuperchain/xuperchain/core/crypto/hash"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"regexp"
+	"strings"
+)
+
+const (
+	WASM_RUNTIME_GO = "go"
+	WASM_RUNTIME_C  = "c"
+
+	//KernelContractAddress 系统合约的合约地址，用于合约发布和账户管理
+	KernelContractAddress = MODULE_XKERNEL + ":"
+
+	//DeployABI 系统合约Deploy方法的ABI
+	DeployABI = `[{"constant":false,"inputs":[{"name":"account_name","type":"string"},{"name":"contract_name","type":"string"},{"name":"contract_code","type":"bytes"},{"name":"contract_desc","type":"bytes"},{"name":"init_args","type":"string"}],"name":"Deploy","outputs":[],"payable":false,"type":"function"}]`
+
+	//UpgradeABI 系统合约Upgrade方法的ABI，account_name用于查找合约账户的ACL授权
+	UpgradeABI = `[{"constant":false,"inputs":[{"name":"account_name","type":"string"},{"name":"contract_name","type":"string"},{"name":"contract_code","type":"bytes"}],"name":"Upgrade","outputs":[],"payable":false,"type":"function"}]`
+)
+
+var (
+	wasmMagic         = []byte{0x00, 0x61, 0x73, 0x6d}
+	contractNameRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.]{3,15}$`)
+)
+
+//WasmDeployParam wasm合约发布参数
+type WasmDeployParam struct {
+	AccountName  string            //合约账户，格式为XC+16位数字@链名
+	ContractName string            //合约名称
+	Code         []byte            //wasm合约代码
+	Runtime      string            //合约运行时，go或c
+	InitArgs     map[string]string //合约初始化参数
+}
+
+//WasmDeployReceipt wasm合约发布回执
+type WasmDeployReceipt struct {
+	TxID            string                           //交易单号
+	AccountName     string                           //合约账户
+	ContractName    string                           //合约名称
+	ContractAddress string                           //合约地址，格式为wasm:contract
+	Runtime         string                           //合约运行时
+	CodeHash        string                           //合约代码的双重sha256摘要，与链上WasmCodeDesc.Digest一致
+	Receipt         *openwallet.SmartContractReceipt //交易回执
+}
+
+//WasmUpgradeParam wasm合约升级参数
+type WasmUpgradeParam struct {
+	AccountName  string //合约所属的合约账户
+	ContractName string //合约名称
+	Code         []byte //新的wasm合约代码
+}
+
+//WasmUpgradeReceipt wasm合约升级回执
+type WasmUpgradeReceipt struct {
+	TxID            string                           //交易单号
+	AccountName     string                           //合约账户
+	ContractName    string                           //合约名称
+	ContractAddress string                           //合约地址，格式为wasm:contract
+	OldCodeHash     string                           //升级前的合约代码摘要
+	NewCodeHash     string                           //升级后的合约代码摘要
+	Receipt         *openwallet.SmartContractReceipt //交易回执
+}
+
+//Validate 本地检查发布参数
+func (p *WasmDeployParam) Validate() error {
+
+	if err := validateWasmContract(p.AccountName, p.ContractName, p.Code); err != nil {
+		return err
+	}
+
+	switch p.Runtime {
+	case WASM_RUNTIME_GO, WASM_RUNTIME_C:
+	default:
+		return fmt.Errorf("contract runtime: %s is not supported", p.Runtime)
+	}
+
+	return nil
+}
+
+//Validate 本地检查升级参数
+func (p *WasmUpgradeParam) Validate() error {
+	return validateWasmContract(p.AccountName, p.ContractName, p.Code)
+}
+
+//validateWasmContract 检查合约账户、合约名称及代码
+func validateWasmContract(accountName, contractName string, code []byte) error {
+
+	if !strings.HasPrefix(accountName, "XC") || !strings.Contains(accountName, "@") {
+		return fmt.Errorf("contract account: %s is invalid", accountName)
+	}
+
+	if !contractNameRegex.MatchString(contractName) {
+		return fmt.Errorf("contract name: %s is invalid", contractName)
+	}
+
+	if len(code) < len(wasmMagic) || !bytes.Equal(code[:len(wasmMagic)], wasmMagic) {
+		return fmt.Errorf("contract code is not wasm binary")
+	}
+
+	return nil
+}
+
+//wasmCodeHash 合约代码摘要，与链上记录的WasmCodeDesc.Digest一致
+func wasmCodeHash(code []byte) string {
+	return hex.EncodeToString(hash.DoubleSha256(code))
+}
+
+//NewWasmDeployABIParam 创建系统合约Deploy方法的ABIParam
+func NewWasmDeployABIParam(param *WasmDeployParam) ([]string, error) {
+
+	if err := param.Validate(); err != nil {
+		return nil, err
+	}
+
+	contractDesc, err := proto.Marshal(&pb.WasmCodeDesc{
+		Runtime: param.Runtime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//初始化参数的值为bytes，json编码后为base64
+	initArgs := make(map[string][]byte)
+	for key, value := range param.InitArgs {
+		initArgs[key] = []byte(value)
+	}
+	initArgsJSON, err := json.Marshal(initArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		METHOD_DEPLOY,
+		param.AccountName,
+		param.ContractName,
+		hex.EncodeToString(param.Code),
+		hex.EncodeToString(contractDesc),
+		string(initArgsJSON),
+	}, nil
+}
+
+//NewWasmUpgradeABIParam 创建系统合约Upgrade方法的ABIParam
+func NewWasmUpgradeABIParam(param *WasmUpgradeParam) ([]string, error) {
+
+	if err := param.Validate(); err != nil {
+		return nil, err
+	}
+
+	return []string{
+		METHOD_UPGRADE,
+		param.AccountName,
+		param.ContractName,
+		hex.EncodeToString(param.Code),
+	}, nil
+}
+
+//CreateWasmDeployRawTransaction 创建wasm合约发布的原始交易单，合约账户的ACL地址需要签名
+func (decoder *ContractDecoder) CreateWasmDeployRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, param *WasmDeployParam) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	abiParam, err := NewWasmDeployABIParam(param)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	return decoder.createKernelRawTransaction(wrapper, account, DeployABI, abiParam)
+}
+
+//CreateWasmUpgradeRawTransaction 创建wasm合约升级的原始交易单，合约需存在于合约账户，合约账户的ACL地址需要签名
+func (decoder *ContractDecoder) CreateWasmUpgradeRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, param *WasmUpgradeParam) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	abiParam, err := NewWasmUpgradeABIParam(param)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	return decoder.createKernelRawTransaction(wrapper, account, UpgradeABI, abiParam)
+}
+
+//createKernelRawTransaction 创建系统合约调用的原始交易单
+func (decoder *ContractDecoder) createKernelRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, abiJSON string, abiParam []string) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotFound, "assets account is empty")
+	}
+
+	contract := openwallet.SmartContract{
+		Address: KernelContractAddress,
+		Symbol:  decoder.wm.Symbol(),
+	}
+	contract.SetABI(abiJSON)
+
+	rawTx := &openwallet.SmartContractRawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     decoder.wm.Symbol(),
+			IsContract: true,
+			Contract:   contract,
+		},
+		Account:  account,
+		ABIParam: abiParam,
+	}
+
+	if createErr := decoder.CreateSmartContractRawTransaction(wrapper, rawTx); createErr != nil {
+		return nil, createErr
+	}
+
+	return rawTx, nil
+}
+
+//SubmitWasmDeployRawTransaction 广播已签名的wasm合约发布交易单，返回发布回执
+func (decoder *ContractDecoder) SubmitWasmDeployRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*WasmDeployReceipt, *openwallet.Error) {
+
+	if len(rawTx.ABIParam) != 6 || rawTx.ABIParam[0] != METHOD_DEPLOY {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "raw transaction is not wasm deploy")
+	}
+
+	code, err := hex.DecodeString(rawTx.ABIParam[3])
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract code is invalid")
+	}
+
+	desc := &pb.WasmCodeDesc{}
+	descBytes, _ := hex.DecodeString(rawTx.ABIParam[4])
+	if err = proto.Unmarshal(descBytes, desc); err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract desc is invalid")
+	}
+
+	receipt, submitErr := decoder.SubmitSmartContractRawTransaction(wrapper, rawTx)
+	if submitErr != nil {
+		return nil, submitErr
+	}
+
+	deployReceipt := &WasmDeployReceipt{
+		TxID:            rawTx.TxID,
+		AccountName:     rawTx.ABIParam[1],
+		ContractName:    rawTx.ABIParam[2],
+		ContractAddress: MODULE_WASM + ":" + rawTx.ABIParam[2],
+		Runtime:         desc.Runtime,
+		CodeHash:        wasmCodeHash(code),
+		Receipt:         receipt,
+	}
+
+	return deployReceipt, nil
+}
+
+//SubmitWasmUpgradeRawTransaction 广播已签名的wasm合约升级交易单，返回包含新旧代码摘要的升级回执
+func (decoder *ContractDecoder) SubmitWasmUpgradeRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*WasmUpgradeReceipt, *openwallet.Error) {
+
+	if len(rawTx.ABIParam) != 4 || rawTx.ABIParam[0] != METHOD_UPGRADE {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "raw transaction is not wasm upgrade")
+	}
+
+	accountName := rawTx.ABIParam[1]
+	contractName := rawTx.ABIParam[2]
+
+	code, err := hex.DecodeString(rawTx.ABIParam[3])
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract code is invalid")
+	}
+
+	//广播前记录升级前的代码摘要
+	oldCodeHash, findErr := decoder.getContractCodeHash(accountName, contractName)
+	if findErr != nil {
+		return nil, findErr
+	}
+
+	receipt, submitErr := decoder.SubmitSmartContractRawTransaction(wrapper, rawTx)
+	if submitErr != nil {
+		return nil, submitErr
+	}
+
+	upgradeReceipt := &WasmUpgradeReceipt{
+		TxID:            rawTx.TxID,
+		AccountName:     accountName,
+		ContractName:    contractName,
+		ContractAddress: MODULE_WASM + ":" + contractName,
+		OldCodeHash:     oldCodeHash,
+		NewCodeHash:     wasmCodeHash(code),
+		Receipt:         receipt,
+	}
+
+	return upgradeReceipt, nil
+}
+
+//getContractCodeHash 查找合约账户下的合约，返回链上记录的代码摘要
+func (decoder *ContractDecoder) getContractCodeHash(accountName, contractName string) (string, *openwallet.Error) {
+
+	contracts, err := decoder.wm.RPC.GetAccountContracts(accountName)
+	if err != nil {
+		return "", openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	for _, c := range contracts {
+		if c.GetContractName() != contractName {
+			continue
+		}
+		desc := &pb.WasmCodeDesc{}
+		if err = proto.Unmarshal(c.GetDesc(), desc); err != nil {
+			return "", openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract: %s desc is invalid", contractName)
+		}
+		return hex.EncodeToString(desc.GetDigest()), nil
+	}
+
+	return "", openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "can not find contract: %s in account: %s", contractName, accountName)
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/tidwall/gjson"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"strings"
+)
+
+//extractKeyEvents 提取合约写入TxOutputsExt的事件，事件的key按合约配置
+//consumedLogs记录已归属回执的日志，ownBucket判断日志是否属于当前调用
+func (bs *BlockScanner) extractKeyEvents(trx *pb.Transaction, moduleName string, ownBucket func(bucket string) bool, consumedLogs map[int]bool, scanAddressFunc openwallet.BlockScanTargetFuncV2) ([]*openwallet.SmartContractEvent, error) {
+
+	events := make([]*openwallet.SmartContractEvent, 0)
+
+	for logIndex, outPutExt := range trx.GetTxOutputsExt() {
+
+		bucket := outPutExt.Bucket
+		bucketContractAddress := moduleName + ":" + bucket
+
+		if string(outPutExt.Key) != bs.wm.Config.EventKey(bucketContractAddress) {
+			continue
+		}
+
+		if consumedLogs[logIndex] || !ownBucket(bucket) {
+			continue
+		}
+		consumedLogs[logIndex] = true
+
+		logTargetResult := scanAddressFunc(openwallet.ScanTargetParam{
+			ScanTarget:     bucketContractAddress,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeContractAddress})
+		if !logTargetResult.Exist {
+			continue
+		}
+
+		logContract, logOk := logTargetResult.TargetInfo.(*openwallet.SmartContract)
+		if !logOk {
+			return nil, fmt.Errorf("log target result can not convert to openwallet.SmartContract")
+		}
+
+		abiInstance, _ := abi.JSON(strings.NewReader(logContract.GetABI()))
+
+		eventJSON := gjson.ParseBytes(outPutExt.Value)
+		for _, e := range eventJSON.Array() {
+			name := e.Get("event").String()
+			events = append(events, &openwallet.SmartContractEvent{
+				Contract: logContract,
+				Event:    name,
+				Value:    bs.decodeABIEvent(abiInstance, name, []byte(e.Get("value").Raw)),
+			})
+		}
+	}
+
+	return events, nil
+}
+
+//extractNativeEvents 提取合约的原生事件，evm合约的日志按solidity abi解码，其余按abi事件定义解码
+func (bs *BlockScanner) extractNativeEvents(trx *pb.Transaction, contract *openwallet.SmartContract) ([]*openwallet.SmartContractEvent, error) {
+
+	events := make([]*openwallet.SmartContractEvent, 0)
+
+	moduleName, contractName := splitContractAddress(contract.Address)
+
+	//没有纪录ABI的evm合约无法解码日志
+	if len(contract.GetABI()) == 0 && moduleName == MODULE_EVM {
+		return events, nil
+	}
+
+	//非evm合约的abi无法解析时保留事件原始值
+	abiInstance, err := abi.JSON(strings.NewReader(contract.GetABI()))
+	if err != nil && moduleName == MODULE_EVM {
+		return nil, err
+	}
+
+	contractEvents, err := parseContractEvents(trx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ce := range contractEvents {
+		if ce.Contract != contractName {
+			continue
+		}
+
+		if moduleName == MODULE_EVM {
+			name, value, decErr := decodeEVMLog(abiInstance, ce.Body)
+			if decErr != nil {
+				//abi未定义的日志不处理
+				bs.wm.Log.Debugf("decode evm log failed, err: %v", decErr)
+				continue
+			}
+			events = append(events, &openwallet.SmartContractEvent{
+				Contract: contract,
+				Event:    name,
+				Value:    value,
+			})
+			continue
+		}
+
+		events = append(events, &openwallet.SmartContractEvent{
+			Contract: contract,
+			Event:    ce.Name,
+			Value:    bs.decodeABIEvent(abiInstance, ce.Name, ce.Body),
+		})
+	}
+
+	return events, nil
+}
+
+//decodeABIEvent 按abi事件定义解码事件值为json，abi未定义或解码失败时保留原始值
+func (bs *BlockScanner) decodeABIEvent(abiInstance abi.ABI, name string, value []byte) string {
+
+	event, ok := abiInstance.Events[name]
+	if !ok {
+		return string(value)
+	}
+
+	fields, err := decodeEventFields(event, value)
+	if err != nil {
+		bs.wm.Log.Debugf("decode event %s failed, err: %v", name, err)
+		return string(value)
+	}
+
+	fieldsJSON, err := json.Marshal(fields)
+	if err != nil {
+		return string(value)
+	}
+
+	return string(fieldsJSON)
+}
+
+//decodeEventFields 按事件参数解码为命名的字段
+//事件值为json对象时按参数名取值，只有一个参数时也可直接为参数值
+func decodeEventFields(event abi.Event, value []byte) (map[string]interface{}, error) {
+
+	var raw interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		//非json的值作为字符串
+		raw = string(value)
+	}
+
+	fields := make(map[string]interface{})
+
+	obj, isObj := raw.(map[string]interface{})
+	if !isObj {
+		if len(event.Inputs) != 1 {
+			return nil, fmt.Errorf("event value must be json object")
+		}
+		obj = map[string]interface{}{abiArgumentName(event.Inputs[0], 0): raw}
+	}
+
+	for i, input := range event.Inputs {
+		name := abiArgumentName(input, i)
+		field, exist := obj[name]
+		if !exist {
+			return nil, fmt.Errorf("event field %s is missing", name)
+		}
+		typed, err := typedABIValue(input.Type, field, name)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = typed
+	}
+
+	return fields, nil
+}
+
+//typedABIValue 按abi类型转化json值，整数转为十进制字符串
+func typedABIValue(t abi.Type, raw interface{}, path string) (interface{}, error) {
+
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		num, err := parseABIInt(t, raw, path)
+		if err != nil {
+			return nil, err
+		}
+		return num.String(), nil
+	case abi.BoolTy:
+		return parseABIBool(raw, path)
+	case abi.StringTy, abi.AddressTy, abi.BytesTy, abi.FixedBytesTy, abi.FunctionTy, abi.HashTy:
+		return abiString(raw, path)
+	case abi.SliceTy, abi.ArrayTy:
+		list, err := parseABIList(t, raw, path)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0, len(list))
+		for i, item := range list {
+			value, err := typedABIValue(*t.Elem, item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case abi.TupleTy:
+		fields, err := parseABITuple(t, raw, path)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{})
+		for i, elemType := range t.TupleElems {
+			name := t.TupleRawNames[i]
+			value, err := typedABIValue(*elemType, fields[i], path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			values[name] = value
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("%s: unsupported abi type: %s", path, t.String())
+}
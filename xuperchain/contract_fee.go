@@ -0,0 +1,185 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"math/big"
+)
+
+//ContractFeeOption 本次合约调用的手续费选项，为空的项使用配置的值，金额单位为主币
+type ContractFeeOption struct {
+	GasMultiplier string //预执行gas的放大倍数，不小于1
+	MaxFee        string //手续费上限，0为不限制
+	MinFee        string //手续费下限
+}
+
+//ContractFeeLimit 交易单实际使用的手续费限制，金额单位为主币
+type ContractFeeLimit struct {
+	GasUsed       int64  `json:"gasUsed"`       //预执行的gas
+	GasMultiplier string `json:"gasMultiplier"` //预执行gas的放大倍数
+	MaxFee        string `json:"maxFee"`        //手续费上限，0为不限制
+	MinFee        string `json:"minFee"`        //手续费下限
+	Fee           string `json:"fee"`           //交易单的手续费
+}
+
+//contractFeeLimit 合约调用的手续费限制，金额单位为最小单位
+type contractFeeLimit struct {
+	Multiplier decimal.Decimal //预执行gas的放大倍数，不小于1
+	MaxFee     *big.Int        //手续费上限，0为不限制
+	MinFee     *big.Int        //手续费下限
+}
+
+//newContractFeeLimit 手续费限制，option中未设置的项使用配置的值
+func newContractFeeLimit(config *ChainConfig, option *ContractFeeOption, decimals int32) (*contractFeeLimit, error) {
+
+	if option == nil {
+		option = &ContractFeeOption{}
+	}
+
+	multiplier, err := feeOptionValue(option.GasMultiplier, config.ContractGasMultiplier, "gas multiplier")
+	if err != nil {
+		return nil, err
+	}
+	maxFee, err := feeOptionValue(option.MaxFee, config.ContractMaxFee, "max fee")
+	if err != nil {
+		return nil, err
+	}
+	minFee, err := feeOptionValue(option.MinFee, config.ContractMinFee, "min fee")
+	if err != nil {
+		return nil, err
+	}
+
+	//gas少于预执行结果，交易会执行失败
+	if multiplier.LessThan(decimal.New(1, 0)) {
+		return nil, fmt.Errorf("gas multiplier: %s must not be less than 1", multiplier.String())
+	}
+	if err = validateFeeLimit(maxFee, minFee); err != nil {
+		return nil, err
+	}
+
+	return &contractFeeLimit{
+		Multiplier: multiplier,
+		MaxFee:     common.StringNumToBigIntWithExp(maxFee.String(), decimals),
+		MinFee:     common.StringNumToBigIntWithExp(minFee.String(), decimals),
+	}, nil
+}
+
+//validateFeeLimit 检查手续费上下限，下限不能超过上限，否则下限会覆盖上限
+func validateFeeLimit(maxFee, minFee decimal.Decimal) error {
+	if maxFee.IsNegative() || minFee.IsNegative() {
+		return fmt.Errorf("max fee: %s and min fee: %s must not be negative", maxFee.String(), minFee.String())
+	}
+	if maxFee.IsPositive() && minFee.GreaterThan(maxFee) {
+		return fmt.Errorf("min fee: %s must not be greater than max fee: %s", minFee.String(), maxFee.String())
+	}
+	return nil
+}
+
+//feeOptionValue 手续费选项的值，为空时使用配置的值
+func feeOptionValue(value string, defaultValue decimal.Decimal, name string) (decimal.Decimal, error) {
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	v, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%s: %s is invalid", name, value)
+	}
+	return v, nil
+}
+
+//result 交易单实际使用的手续费限制
+func (limit *contractFeeLimit) result(gasUsed int64, fee *big.Int, decimals int32) *ContractFeeLimit {
+	return &ContractFeeLimit{
+		GasUsed:       gasUsed,
+		GasMultiplier: limit.Multiplier.String(),
+		MaxFee:        common.BigIntToDecimals(limit.MaxFee, decimals).String(),
+		MinFee:        common.BigIntToDecimals(limit.MinFee, decimals).String(),
+		Fee:           common.BigIntToDecimals(fee, decimals).String(),
+	}
+}
+
+//Fee 按预执行的gas计算手续费，放大后的手续费不超过上限，预执行的gas超过上限返回错误
+func (limit *contractFeeLimit) Fee(gasUsed int64) (*big.Int, error) {
+
+	gas := big.NewInt(gasUsed)
+
+	fee := decimal.NewFromBigInt(gas, 0).Mul(limit.Multiplier).Ceil().BigInt()
+	if fee.Cmp(limit.MinFee) < 0 {
+		fee = new(big.Int).Set(limit.MinFee)
+	}
+
+	if limit.MaxFee.Sign() > 0 {
+		if gas.Cmp(limit.MaxFee) > 0 {
+			return nil, fmt.Errorf("pre-executed gas: %s exceeds max fee: %s", gas.String(), limit.MaxFee.String())
+		}
+		if fee.Cmp(limit.MaxFee) > 0 {
+			fee = new(big.Int).Set(limit.MaxFee)
+		}
+	}
+
+	return fee, nil
+}
+
+//GetContractFeeLimit 获取交易单创建时实际使用的手续费限制，交易单广播后不再保留
+func (decoder *ContractDecoder) GetContractFeeLimit(rawTx *openwallet.SmartContractRawTransaction) (*ContractFeeLimit, bool) {
+	limit, ok := decoder.feeLimits.Load(rawTxDigest(rawTx))
+	if !ok {
+		return nil, false
+	}
+	return limit.(*ContractFeeLimit), true
+}
+
+//rawTxDigest 交易单的摘要，所有待签名的KeySignature的消息相同
+func rawTxDigest(rawTx *openwallet.SmartContractRawTransaction) string {
+	for _, keySignatures := range rawTx.Signatures {
+		for _, keySignature := range keySignatures {
+			return keySignature.Message
+		}
+	}
+	return ""
+}
+
+//selectFeeUTXO 手续费超过预执行选出的utxo时，按手续费重新选择手续费地址的utxo
+func (decoder *ContractDecoder) selectFeeUTXO(feeAddress string, fee *big.Int, preResp *pb.PreExecWithSelectUTXOResponse) ([]*pb.Utxo, *big.Int, error) {
+
+	total, _ := new(big.Int).SetString(preResp.GetUtxoOutput().GetTotalSelected(), 10)
+	if total == nil {
+		total = big.NewInt(0)
+	}
+	if total.Cmp(fee) >= 0 {
+		return preResp.GetUtxoOutput().GetUtxoList(), total, nil
+	}
+
+	utxoList, err := decoder.wm.RPC.SelectUTXO(feeAddress, fee.String(), false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("select utxo for fee: %s failed, err: %v", fee.String(), err)
+	}
+
+	total = big.NewInt(0)
+	for _, utxo := range utxoList {
+		total.Add(total, new(big.Int).SetBytes(utxo.Amount))
+	}
+	if total.Cmp(fee) < 0 {
+		return nil, nil, fmt.Errorf("address: %s balance is not enough for fee: %s", feeAddress, fee.String())
+	}
+
+	return utxoList, total, nil
+}
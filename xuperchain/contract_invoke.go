@@ -0,0 +1,162 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"fmt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"strings"
+)
+
+//ContractInvokeCall 原子交易中的单个合约调用
+type ContractInvokeCall struct {
+	Address  string   `json:"address"`  //合约地址，格式为module:contract
+	ABI      string   `json:"abi"`      //合约ABI，为空时使用交易的合约ABI
+	ABIParam []string `json:"abiParam"` //调用方法及参数
+}
+
+//contractInvoke 编码后的合约调用
+type contractInvoke struct {
+	call        *ContractInvokeCall
+	abiInstance abi.ABI
+	request     *pb.InvokeRequest
+}
+
+//contractInvokeCalls 交易的合约调用列表，multiCalls为空时为交易的合约和ABIParam的单个调用
+//multiCalls中未设置地址或ABI的调用使用交易的合约
+func contractInvokeCalls(rawTx *openwallet.SmartContractRawTransaction, multiCalls []*ContractInvokeCall) ([]*ContractInvokeCall, error) {
+
+	if multiCalls == nil {
+		if len(rawTx.ABIParam) == 0 {
+			return nil, fmt.Errorf("abi param length is empty")
+		}
+		return []*ContractInvokeCall{
+			{
+				Address:  rawTx.Coin.Contract.Address,
+				ABI:      rawTx.Coin.Contract.GetABI(),
+				ABIParam: rawTx.ABIParam,
+			},
+		}, nil
+	}
+
+	if len(multiCalls) == 0 {
+		return nil, fmt.Errorf("contract invoke calls is empty")
+	}
+
+	calls := make([]*ContractInvokeCall, 0, len(multiCalls))
+	for i, call := range multiCalls {
+		if call == nil || len(call.ABIParam) == 0 {
+			return nil, fmt.Errorf("contract invoke call [%d] abi param is empty", i)
+		}
+		c := *call
+		if len(c.Address) == 0 {
+			c.Address = rawTx.Coin.Contract.Address
+		}
+		if len(c.ABI) == 0 {
+			c.ABI = rawTx.Coin.Contract.GetABI()
+		}
+		calls = append(calls, &c)
+	}
+
+	return calls, nil
+}
+
+//encodeContractInvokes 编码交易的所有合约调用，multiCalls为空时为交易的单个调用
+func (decoder *ContractDecoder) encodeContractInvokes(rawTx *openwallet.SmartContractRawTransaction, multiCalls []*ContractInvokeCall) ([]*contractInvoke, *openwallet.Error) {
+
+	calls, err := contractInvokeCalls(rawTx, multiCalls)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	invokes := make([]*contractInvoke, 0, len(calls))
+	for i, call := range calls {
+
+		if len(call.ABI) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract invoke call [%d] abi json is empty", i)
+		}
+		abiInstance, abiErr := abi.JSON(strings.NewReader(call.ABI))
+		if abiErr != nil {
+			return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract invoke call [%d] %v", i, abiErr)
+		}
+
+		invokeRequest, encErr := decoder.wm.EncodeInvokeRequest(abiInstance, call.Address, call.ABIParam...)
+		if encErr != nil {
+			return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "contract invoke call [%d] %v", i, encErr)
+		}
+
+		invokes = append(invokes, &contractInvoke{
+			call:        call,
+			abiInstance: abiInstance,
+			request:     invokeRequest,
+		})
+	}
+
+	return invokes, nil
+}
+
+//invokeMethod 主调用的方法名，主调用为最后一个调用
+func invokeMethod(invokes []*contractInvoke) string {
+	if len(invokes) == 0 {
+		return ""
+	}
+	return invokes[len(invokes)-1].request.GetMethodName()
+}
+
+//invokeRequests 合约调用的请求列表
+func invokeRequests(invokes []*contractInvoke) []*pb.InvokeRequest {
+	requests := make([]*pb.InvokeRequest, 0, len(invokes))
+	for _, invoke := range invokes {
+		requests = append(requests, invoke.request)
+	}
+	return requests
+}
+
+//invokeABIs 合约调用的ABI列表
+func invokeABIs(invokes []*contractInvoke) []abi.ABI {
+	abis := make([]abi.ABI, 0, len(invokes))
+	for _, invoke := range invokes {
+		abis = append(abis, invoke.abiInstance)
+	}
+	return abis
+}
+
+//contractAddressesOfRequests 合约调用的目标地址，多个合约用逗号分隔
+func contractAddressesOfRequests(requests []*pb.InvokeRequest) string {
+	to := make([]string, 0, len(requests))
+	exist := make(map[string]bool)
+	for _, req := range requests {
+		address := req.GetModuleName() + ":" + req.GetContractName()
+		if exist[address] {
+			continue
+		}
+		exist[address] = true
+		to = append(to, address)
+	}
+	return strings.Join(to, ",")
+}
+
+//hasAuthRequire 是否已包含授权签名
+func hasAuthRequire(authRequire []string, auth string) bool {
+	for _, a := range authRequire {
+		if a == auth {
+			return true
+		}
+	}
+	return false
+}
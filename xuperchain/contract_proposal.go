@@ -0,0 +1,376 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	xupercom "github.com/xuperchain/xuper-sdk-go/common"
+	"github.com/xuperchain/xuperchain/core/global"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"math/big"
+	"time"
+)
+
+const (
+	MODULE_PROPOSAL = "proposal"
+	METHOD_PROPOSE  = "Propose"
+	METHOD_VOTE     = "Vote"
+	METHOD_THAW     = "Thaw"
+
+	//ProposalContractAddress 提案合约的地址，提案、投票通过交易的Desc执行
+	ProposalContractAddress = MODULE_PROPOSAL + ":"
+
+	ProposalStatusUnconfirmed    = "unconfirmed"          //提案交易未上链
+	ProposalStatusVoting         = "voting"               //投票中
+	ProposalStatusVoteEnded      = "voteEnded"            //投票已截止
+	ProposalStatusTriggerReached = "triggerHeightReached" //已到达触发高度，投票未通过的提案不会执行
+)
+
+//ProposalTrigger 提案通过后在触发高度执行的系统合约方法
+type ProposalTrigger struct {
+	Height int64                  `json:"height"` //触发高度，需大于投票截止高度
+	Module string                 `json:"module"` //系统合约模块，如consensus
+	Method string                 `json:"method"` //系统合约方法，如update_consensus
+	Args   map[string]interface{} `json:"args"`   //方法参数
+}
+
+//ProposalArgs 提案的投票参数
+type ProposalArgs struct {
+	MinVotePercent int   `json:"min_vote_percent"` //通过提案需要的最低投票比例，为全网总金额的百分比
+	StopVoteHeight int64 `json:"stop_vote_height"` //投票截止高度
+}
+
+//ProposalDesc 提案交易的Desc
+type ProposalDesc struct {
+	Module  string           `json:"module"`
+	Method  string           `json:"method"`
+	Args    ProposalArgs     `json:"args"`
+	Trigger *ProposalTrigger `json:"trigger"`
+}
+
+//proposalTxDesc 投票和撤销提案交易的Desc
+type proposalTxDesc struct {
+	Module string            `json:"module"`
+	Method string            `json:"method"`
+	Args   map[string]string `json:"args"`
+}
+
+//ProposalStatus 提案状态
+type ProposalStatus struct {
+	TxID          string        `json:"txid"`          //提案交易ID
+	Initiator     string        `json:"initiator"`     //提案发起者
+	Proposal      *ProposalDesc `json:"proposal"`      //提案内容
+	Status        string        `json:"status"`        //提案状态
+	CurrentHeight int64         `json:"currentHeight"` //当前链高度
+}
+
+//NewProposalDesc 创建提案的Desc
+func NewProposalDesc(minVotePercent int, stopVoteHeight int64, trigger *ProposalTrigger) *ProposalDesc {
+	return &ProposalDesc{
+		Module: MODULE_PROPOSAL,
+		Method: METHOD_PROPOSE,
+		Args: ProposalArgs{
+			MinVotePercent: minVotePercent,
+			StopVoteHeight: stopVoteHeight,
+		},
+		Trigger: trigger,
+	}
+}
+
+//Validate 本地检查提案参数
+func (desc *ProposalDesc) Validate(currentHeight int64) error {
+
+	if desc.Args.MinVotePercent <= 0 || desc.Args.MinVotePercent > 100 {
+		return fmt.Errorf("min vote percent: %d must be in (0, 100]", desc.Args.MinVotePercent)
+	}
+
+	if desc.Args.StopVoteHeight <= currentHeight {
+		return fmt.Errorf("stop vote height: %d must be greater than current height: %d", desc.Args.StopVoteHeight, currentHeight)
+	}
+
+	if desc.Trigger == nil {
+		return fmt.Errorf("proposal trigger is empty")
+	}
+
+	if len(desc.Trigger.Module) == 0 || len(desc.Trigger.Method) == 0 {
+		return fmt.Errorf("proposal trigger module or method is empty")
+	}
+
+	if desc.Trigger.Height <= desc.Args.StopVoteHeight {
+		return fmt.Errorf("trigger height: %d must be greater than stop vote height: %d", desc.Trigger.Height, desc.Args.StopVoteHeight)
+	}
+
+	return nil
+}
+
+//CreateProposalRawTransaction 创建提案的原始交易单
+func (decoder *ContractDecoder) CreateProposalRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, desc *ProposalDesc) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if desc == nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "proposal is empty")
+	}
+
+	currentHeight, err := decoder.currentHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	desc.Module = MODULE_PROPOSAL
+	desc.Method = METHOD_PROPOSE
+	if valErr := desc.Validate(currentHeight); valErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, valErr.Error())
+	}
+
+	descJSON, jsonErr := json.Marshal(desc)
+	if jsonErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, jsonErr.Error())
+	}
+
+	abiParam := []string{METHOD_PROPOSE, string(descJSON)}
+
+	return decoder.createDescRawTransaction(wrapper, account, ProposalContractAddress, descJSON, abiParam, big.NewInt(0), 0)
+}
+
+//CreateVoteRawTransaction 创建提案投票的原始交易单，投票金额冻结到frozenHeight，冻结高度需大于投票截止高度
+func (decoder *ContractDecoder) CreateVoteRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, proposalTxID string, amount string, frozenHeight int64) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	voteAmount := common.StringNumToBigIntWithExp(amount, decoder.wm.Decimal())
+	if voteAmount.Sign() <= 0 {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "vote amount: %s must be greater than 0", amount)
+	}
+
+	status, err := decoder.QueryProposalStatus(proposalTxID)
+	if err != nil {
+		return nil, err
+	}
+
+	if status.Status != ProposalStatusVoting {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "proposal: %s is %s, can not vote", proposalTxID, status.Status)
+	}
+
+	//冻结的金额在投票截止前需保持冻结
+	if frozenHeight <= status.Proposal.Args.StopVoteHeight {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "frozen height: %d must be greater than stop vote height: %d", frozenHeight, status.Proposal.Args.StopVoteHeight)
+	}
+
+	descJSON, jsonErr := json.Marshal(proposalTxDesc{
+		Module: MODULE_PROPOSAL,
+		Method: METHOD_VOTE,
+		Args:   map[string]string{"txid": proposalTxID},
+	})
+	if jsonErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, jsonErr.Error())
+	}
+
+	abiParam := []string{METHOD_VOTE, proposalTxID, amount, fmt.Sprintf("%d", frozenHeight)}
+
+	return decoder.createDescRawTransaction(wrapper, account, ProposalContractAddress, descJSON, abiParam, voteAmount, frozenHeight)
+}
+
+//CreateThawRawTransaction 创建撤销提案的原始交易单，只有提案发起者可在投票截止前撤销，撤销后投票冻结的金额解冻
+func (decoder *ContractDecoder) CreateThawRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, proposalTxID string) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	status, err := decoder.QueryProposalStatus(proposalTxID)
+	if err != nil {
+		return nil, err
+	}
+
+	if status.Status != ProposalStatusVoting {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "proposal: %s is %s, can not thaw", proposalTxID, status.Status)
+	}
+
+	descJSON, jsonErr := json.Marshal(proposalTxDesc{
+		Module: MODULE_PROPOSAL,
+		Method: METHOD_THAW,
+		Args:   map[string]string{"txid": proposalTxID},
+	})
+	if jsonErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, jsonErr.Error())
+	}
+
+	abiParam := []string{METHOD_THAW, proposalTxID}
+
+	return decoder.createDescRawTransaction(wrapper, account, ProposalContractAddress, descJSON, abiParam, big.NewInt(0), 0)
+}
+
+//QueryProposalStatus 查询提案的内容和状态
+func (decoder *ContractDecoder) QueryProposalStatus(proposalTxID string) (*ProposalStatus, *openwallet.Error) {
+
+	txStatus, queryErr := decoder.wm.RPC.QueryTx(proposalTxID)
+	if queryErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "can not find proposal: %s, err: %v", proposalTxID, queryErr)
+	}
+
+	var desc ProposalDesc
+	if jsonErr := json.Unmarshal(txStatus.GetTx().GetDesc(), &desc); jsonErr != nil || desc.Module != MODULE_PROPOSAL || desc.Method != METHOD_PROPOSE {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "transaction: %s is not proposal", proposalTxID)
+	}
+
+	currentHeight, err := decoder.currentHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ProposalStatus{
+		TxID:          proposalTxID,
+		Initiator:     txStatus.GetTx().GetInitiator(),
+		Proposal:      &desc,
+		CurrentHeight: currentHeight,
+	}
+
+	switch {
+	case txStatus.GetStatus() != pb.TransactionStatus_CONFIRM:
+		status.Status = ProposalStatusUnconfirmed
+	case desc.Trigger != nil && currentHeight >= desc.Trigger.Height:
+		status.Status = ProposalStatusTriggerReached
+	case currentHeight >= desc.Args.StopVoteHeight:
+		status.Status = ProposalStatusVoteEnded
+	default:
+		status.Status = ProposalStatusVoting
+	}
+
+	return status, nil
+}
+
+//currentHeight 当前链高度
+func (decoder *ContractDecoder) currentHeight() (int64, *openwallet.Error) {
+	chainStatus, err := decoder.wm.RPC.GetBlockChainStatus()
+	if err != nil {
+		return 0, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+	return chainStatus.GetMeta().GetTrunkHeight(), nil
+}
+
+//isDescContractAddress 是否由Desc执行的系统交易的合约地址，这些交易没有合约调用
+func isDescContractAddress(address string) bool {
+	return address == ProposalContractAddress || address == TDPOSContractAddress
+}
+
+//createDescRawTransaction 创建由Desc执行的系统交易，amount大于0时转给发起者自己并冻结到frozenHeight，-1为永久冻结
+//配置了合约调用的最低手续费时，系统交易同样支付该手续费，与其他交易一样由发起者支付
+//authAddrs为发起者以外需要共同签名的地址
+func (decoder *ContractDecoder) createDescRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, contractAddress string, desc []byte, abiParam []string, amount *big.Int, frozenHeight int64, authAddrs ...*openwallet.Address) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotFound, "assets account is empty")
+	}
+
+	//账户的第一个地址为发起者
+	defAddress, getErr := decoder.GetAssetsAccountDefAddress(wrapper, account.AccountID)
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	tx := &pb.Transaction{
+		Version:     xupercom.TxVersion,
+		Coinbase:    false,
+		Desc:        desc,
+		Nonce:       global.GenNonce(),
+		Timestamp:   time.Now().UnixNano(),
+		Initiator:   defAddress.Address,
+		AuthRequire: []string{defAddress.Address},
+	}
+
+	signAddrs := []*openwallet.Address{defAddress}
+	for _, addr := range authAddrs {
+		if hasAuthRequire(tx.AuthRequire, addr.Address) {
+			continue
+		}
+		tx.AuthRequire = append(tx.AuthRequire, addr.Address)
+		signAddrs = append(signAddrs, addr)
+	}
+
+	//系统交易按合约调用的最低手续费支付手续费，为0时不支付
+	fee := common.StringNumToBigIntWithExp(decoder.wm.Config.ContractMinFee.String(), decoder.wm.Decimal())
+	totalNeed := new(big.Int).Add(amount, fee)
+
+	if totalNeed.Sign() > 0 {
+		utxoList, selErr := decoder.wm.RPC.SelectUTXO(defAddress.Address, totalNeed.String(), false)
+		if selErr != nil {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "select utxo failed, err: %v", selErr)
+		}
+
+		total := big.NewInt(0)
+		for _, utxo := range utxoList {
+			tx.TxInputs = append(tx.TxInputs, &pb.TxInput{
+				RefTxid:   utxo.RefTxid,
+				RefOffset: utxo.RefOffset,
+				FromAddr:  utxo.ToAddr,
+				Amount:    utxo.Amount,
+			})
+			total.Add(total, new(big.Int).SetBytes(utxo.Amount))
+		}
+		if total.Cmp(totalNeed) < 0 {
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "address: %s balance is not enough", defAddress.Address)
+		}
+
+		if amount.Sign() > 0 {
+			tx.TxOutputs = append(tx.TxOutputs, &pb.TxOutput{
+				ToAddr:       []byte(defAddress.Address),
+				Amount:       amount.Bytes(),
+				FrozenHeight: frozenHeight,
+			})
+		}
+
+		// 手续费需要“转账”给地址“$”
+		if fee.Sign() > 0 {
+			tx.TxOutputs = append(tx.TxOutputs, &pb.TxOutput{
+				ToAddr: []byte(FeeAddress),
+				Amount: fee.Bytes(),
+			})
+		}
+
+		if total.Cmp(totalNeed) > 0 {
+			tx.TxOutputs = append(tx.TxOutputs, &pb.TxOutput{
+				ToAddr: []byte(defAddress.Address),
+				Amount: new(big.Int).Sub(total, totalNeed).Bytes(),
+			})
+		}
+	}
+
+	contract := openwallet.SmartContract{
+		Address: contractAddress,
+		Symbol:  decoder.wm.Symbol(),
+	}
+
+	rawTx := &openwallet.SmartContractRawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     decoder.wm.Symbol(),
+			IsContract: true,
+			Contract:   contract,
+		},
+		Account:  account,
+		ABIParam: abiParam,
+	}
+
+	if signErr := decoder.appendRawTxSignatures(rawTx, tx, signAddrs); signErr != nil {
+		return nil, signErr
+	}
+
+	txJSON, _ := json.Marshal(tx)
+	rawTx.Raw = string(txJSON)
+	rawTx.RawType = openwallet.TxRawTypeJSON
+	rawTx.Value = common.BigIntToDecimals(amount, decoder.wm.Decimal()).String()
+	rawTx.Fees = common.BigIntToDecimals(fee, decoder.wm.Decimal()).String()
+	rawTx.IsBuilt = true
+	rawTx.TxFrom = defAddress.Address
+	rawTx.TxTo = contractAddress
+
+	return rawTx, nil
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"math/big"
+	"strings"
+)
+
+const (
+	ContractStatusOK = 200 //合约执行成功的状态码
+)
+
+//ContractCallResponse 合约调用的单个返回结果
+type ContractCallResponse struct {
+	Contract string      `json:"contract"` //合约地址，格式为module:contract
+	Method   string      `json:"method"`   //调用方法
+	Status   int32       `json:"status"`   //合约返回状态
+	Message  string      `json:"message"`  //合约返回信息
+	RawHex   string      `json:"rawHex"`   //原始返回数据
+	Result   interface{} `json:"result"`   //按abi解码的返回值
+}
+
+//ContractCallResult 合约调用结果，包含所有请求的返回值
+type ContractCallResult struct {
+	GasUsed   int64                   `json:"gasUsed"`   //消耗的gas
+	Responses []*ContractCallResponse `json:"responses"` //按请求顺序的返回结果
+}
+
+//Failed 首个失败的返回结果
+func (r *ContractCallResult) Failed() *ContractCallResponse {
+	for _, res := range r.Responses {
+		if res.Status != ContractStatusOK {
+			return res
+		}
+	}
+	return nil
+}
+
+//Primary 主调用的返回结果，即最后一个请求的返回
+func (r *ContractCallResult) Primary() *ContractCallResponse {
+	if len(r.Responses) == 0 {
+		return nil
+	}
+	return r.Responses[len(r.Responses)-1]
+}
+
+//Value 返回结果的值，evm合约为abi解码后的json，其他合约为原始返回数据
+func (res *ContractCallResponse) Value() string {
+
+	if strings.HasPrefix(res.Contract, MODULE_EVM+":") && res.Status == ContractStatusOK {
+		//没有返回值的方法与旧版本一致返回空
+		if fields, ok := res.Result.(map[string]interface{}); res.Result == nil || (ok && len(fields) == 0) {
+			return ""
+		}
+		value, err := json.Marshal(res.Result)
+		if err == nil {
+			return string(value)
+		}
+	}
+
+	body, _ := hex.DecodeString(res.RawHex)
+	return string(body)
+}
+
+//decodeContractCallResult 按abi解码预执行的所有返回结果
+//abis与requests一一对应
+func decodeContractCallResult(abis []abi.ABI, requests []*pb.InvokeRequest, resp *pb.InvokeResponse) (*ContractCallResult, error) {
+
+	result := &ContractCallResult{
+		GasUsed:   resp.GetGasUsed(),
+		Responses: make([]*ContractCallResponse, 0),
+	}
+
+	contractResponses := resp.GetResponses()
+	if len(contractResponses) == 0 {
+		//旧版本节点只返回body
+		for _, body := range resp.GetResponse() {
+			contractResponses = append(contractResponses, &pb.ContractResponse{Status: ContractStatusOK, Body: body})
+		}
+	}
+
+	for i, res := range contractResponses {
+
+		callResponse := &ContractCallResponse{
+			Status:  res.GetStatus(),
+			Message: res.GetMessage(),
+			RawHex:  hex.EncodeToString(res.GetBody()),
+			Result:  string(res.GetBody()),
+		}
+
+		if i < len(requests) {
+			req := requests[i]
+			callResponse.Contract = req.GetModuleName() + ":" + req.GetContractName()
+			callResponse.Method = req.GetMethodName()
+
+			//失败的结果不解码
+			if i < len(abis) && callResponse.Status == ContractStatusOK {
+				if abiMethod, ok := abis[i].Methods[req.GetMethodName()]; ok {
+					value, err := decodeContractOutput(req.GetModuleName(), abiMethod, res.GetBody())
+					if err != nil {
+						return nil, fmt.Errorf("decode %s response failed: %v", req.GetMethodName(), err)
+					}
+					callResponse.Result = value
+				}
+			}
+		}
+
+		result.Responses = append(result.Responses, callResponse)
+	}
+
+	return result, nil
+}
+
+//decodeContractOutput 按abi方法的返回值定义解码合约返回数据
+func decodeContractOutput(moduleName string, abiMethod abi.Method, data []byte) (interface{}, error) {
+
+	if len(abiMethod.Outputs) == 0 {
+		return string(data), nil
+	}
+
+	//evm合约使用solidity abi解码
+	if moduleName == MODULE_EVM {
+		return decodeEVMOutput(abiMethod, data)
+	}
+
+	return decodeWasmOutput(abiMethod, data)
+}
+
+//decodeWasmOutput 解码wasm/native合约返回数据
+//单个返回值按类型解码，多个返回值需为json对象或数组
+func decodeWasmOutput(abiMethod abi.Method, data []byte) (interface{}, error) {
+
+	result := make(map[string]interface{})
+
+	if len(abiMethod.Outputs) == 1 {
+		output := abiMethod.Outputs[0]
+		value, err := decodeWasmValue(output.Type, data)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", abiArgumentName(output, 0), err)
+		}
+		result[abiArgumentName(output, 0)] = value
+		return result, nil
+	}
+
+	raw, err := parseABIParam(abi.Type{T: abi.TupleTy}, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("outputs json is invalid: %v", err)
+	}
+
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		for i, output := range abiMethod.Outputs {
+			name := abiArgumentName(output, i)
+			field, ok := v[name]
+			if !ok {
+				return nil, fmt.Errorf("output %s is missing", name)
+			}
+			result[name] = field
+		}
+	case []interface{}:
+		if len(v) != len(abiMethod.Outputs) {
+			return nil, fmt.Errorf("outputs length is %d, except is %d", len(v), len(abiMethod.Outputs))
+		}
+		for i, output := range abiMethod.Outputs {
+			result[abiArgumentName(output, i)] = v[i]
+		}
+	default:
+		return nil, fmt.Errorf("outputs must be json object or array")
+	}
+
+	return result, nil
+}
+
+//decodeWasmValue 按abi类型解码wasm合约的单个返回值
+func decodeWasmValue(t abi.Type, data []byte) (interface{}, error) {
+
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		if len(data) == 0 {
+			return "0", nil
+		}
+		//wasm合约通常返回数字字符串，否则按大端整数处理
+		s := strings.TrimSpace(string(data))
+		if num, ok := new(big.Int).SetString(s, 10); ok {
+			return num.String(), nil
+		}
+		return new(big.Int).SetBytes(data).String(), nil
+	case abi.BoolTy:
+		s := strings.TrimSpace(string(data))
+		return s == "true" || (len(data) == 1 && data[0] == 0x01), nil
+	case abi.BytesTy, abi.FixedBytesTy, abi.FunctionTy, abi.HashTy:
+		return hex.EncodeToString(data), nil
+	case abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"strings"
+)
+
+//ContractStateChange 交易对合约存储的一次写入
+type ContractStateChange struct {
+	Bucket     string `json:"bucket"`     //合约存储的bucket，一般为合约名
+	Key        string `json:"key"`        //存储key的hex
+	OldVersion string `json:"oldVersion"` //写入前的版本，格式为txid_offset，新增的key为空
+	Value      string `json:"value"`      //写入的新值的hex
+}
+
+//stateKeyPath 用于前缀过滤的存储路径，格式为bucket/key
+func stateKeyPath(bucket string, key []byte) string {
+	return bucket + "/" + string(key)
+}
+
+//stateVersion 存储的版本号，与链上的版本格式一致
+func stateVersion(refTxid []byte, refOffset int32) string {
+	if len(refTxid) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s_%d", hex.EncodeToString(refTxid), refOffset)
+}
+
+//matchStatePrefixes 存储路径是否匹配过滤前缀，未配置前缀时全部匹配
+func matchStatePrefixes(path string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//extractStateChanges 提取交易写入合约存储的变更，旧版本来自TxInputsExt中相同的bucket和key
+//事件和$transient的数据不是合约状态，不提取；consumed记录已归属回执的写入，ownBucket判断写入是否属于当前调用
+func (bs *BlockScanner) extractStateChanges(trx *pb.Transaction, moduleName string, ownBucket func(bucket string) bool, consumed map[int]bool) []*ContractStateChange {
+
+	changes := make([]*ContractStateChange, 0)
+
+	versions := make(map[string]string)
+	for _, inputExt := range trx.GetTxInputsExt() {
+		versions[stateKeyPath(inputExt.GetBucket(), inputExt.GetKey())] = stateVersion(inputExt.GetRefTxid(), inputExt.GetRefOffset())
+	}
+
+	for i, outPutExt := range trx.GetTxOutputsExt() {
+
+		bucket := outPutExt.GetBucket()
+		if bucket == contractEventBucket {
+			continue
+		}
+
+		if string(outPutExt.GetKey()) == bs.wm.Config.EventKey(moduleName+":"+bucket) {
+			continue
+		}
+
+		if consumed[i] || !ownBucket(bucket) {
+			continue
+		}
+		consumed[i] = true
+
+		path := stateKeyPath(bucket, outPutExt.GetKey())
+		if !matchStatePrefixes(path, bs.wm.Config.StateDiffPrefixes) {
+			continue
+		}
+
+		changes = append(changes, &ContractStateChange{
+			Bucket:     bucket,
+			Key:        hex.EncodeToString(outPutExt.GetKey()),
+			OldVersion: versions[path],
+			Value:      hex.EncodeToString(outPutExt.GetValue()),
+		})
+	}
+
+	return changes
+}
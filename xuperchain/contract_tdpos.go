@@ -0,0 +1,291 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"math/big"
+)
+
+const (
+	MODULE_TDPOS                = "tdpos"
+	METHOD_NOMINATE_CANDIDATE   = "nominate_candidate"
+	METHOD_REVOKE_CANDIDATE     = "revoke_candidate"
+	METHOD_TDPOS_VOTE           = "vote"
+	METHOD_REVOKE_VOTE          = "revoke_vote"
+	TDPOS_FROZEN_HEIGHT_FOREVER = -1 //提名和投票的金额永久冻结，撤销后解冻
+
+	//TDPOSContractAddress TDPOS共识的地址，提名、投票通过交易的Desc执行
+	TDPOSContractAddress = MODULE_TDPOS + ":"
+)
+
+//StakingDesc TDPOS提名、投票及撤销交易的Desc
+type StakingDesc struct {
+	Module string                 `json:"module"`
+	Method string                 `json:"method"`
+	Args   map[string]interface{} `json:"args"`
+}
+
+//parseStakingDesc 解析交易Desc中的TDPOS操作
+func parseStakingDesc(desc []byte) (*StakingDesc, bool) {
+
+	if len(desc) == 0 || desc[0] != '{' {
+		return nil, false
+	}
+
+	var staking StakingDesc
+	if err := json.Unmarshal(desc, &staking); err != nil {
+		return nil, false
+	}
+
+	if staking.Module != MODULE_TDPOS {
+		return nil, false
+	}
+
+	switch staking.Method {
+	case METHOD_NOMINATE_CANDIDATE, METHOD_REVOKE_CANDIDATE, METHOD_TDPOS_VOTE, METHOD_REVOKE_VOTE:
+		return &staking, true
+	}
+
+	return nil, false
+}
+
+//Candidates 提名或投票的候选人地址
+func (desc *StakingDesc) Candidates() []string {
+
+	candidates := make([]string, 0)
+
+	if candidate, ok := desc.Args["candidate"].(string); ok && len(candidate) > 0 {
+		candidates = append(candidates, candidate)
+	}
+
+	if list, ok := desc.Args["candidates"].([]interface{}); ok {
+		for _, c := range list {
+			if candidate, isStr := c.(string); isStr && len(candidate) > 0 {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	return candidates
+}
+
+//CreateNominateRawTransaction 创建提名候选人的原始交易单，提名金额永久冻结，候选人地址需共同签名
+func (decoder *ContractDecoder) CreateNominateRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, candidate, netURL, amount string) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if !decoder.wm.AddrDecoder.AddressVerify(candidate) {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "candidate: %s is invalid", candidate)
+	}
+
+	if len(netURL) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "candidate net url is empty")
+	}
+
+	nominateAmount := common.StringNumToBigIntWithExp(amount, decoder.wm.Decimal())
+	if nominateAmount.Sign() <= 0 {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "nominate amount: %s must be greater than 0", amount)
+	}
+
+	txid, err := decoder.wm.RPC.DposNomineeRecords(candidate)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "query candidate: %s nominee records failed, err: %v", candidate, err)
+	}
+	if len(txid) > 0 {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "candidate: %s is already nominated by tx: %s", candidate, txid)
+	}
+
+	candidateAddress, findErr := wrapper.GetAddress(candidate)
+	if findErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrAddressNotFound, "can not find address: %s", candidate)
+	}
+
+	desc := &StakingDesc{
+		Module: MODULE_TDPOS,
+		Method: METHOD_NOMINATE_CANDIDATE,
+		Args: map[string]interface{}{
+			"candidate": candidate,
+			"neturl":    netURL,
+		},
+	}
+
+	abiParam := []string{METHOD_NOMINATE_CANDIDATE, candidate, netURL, amount}
+
+	return decoder.createStakingRawTransaction(wrapper, account, desc, abiParam, nominateAmount, candidateAddress)
+}
+
+//CreateTDPOSVoteRawTransaction 创建给候选人投票的原始交易单，投票金额永久冻结
+func (decoder *ContractDecoder) CreateTDPOSVoteRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, candidates []string, amount string) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	candidates, verifyErr := voteCandidates(candidates, decoder.wm.AddrDecoder.AddressVerify)
+	if verifyErr != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, verifyErr.Error())
+	}
+
+	voteAmount := common.StringNumToBigIntWithExp(amount, decoder.wm.Decimal())
+	if voteAmount.Sign() <= 0 {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "vote amount: %s must be greater than 0", amount)
+	}
+
+	//只能投票给已提名的候选人
+	candidateList, err := decoder.wm.RPC.DposCandidates()
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+	nominated := make(map[string]bool)
+	for _, c := range candidateList {
+		nominated[c.GetAddress()] = true
+	}
+
+	list := make([]interface{}, 0, len(candidates))
+	for _, candidate := range candidates {
+		if !nominated[candidate] {
+			return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "candidate: %s is not nominated", candidate)
+		}
+		list = append(list, candidate)
+	}
+
+	desc := &StakingDesc{
+		Module: MODULE_TDPOS,
+		Method: METHOD_TDPOS_VOTE,
+		Args: map[string]interface{}{
+			"candidates": list,
+		},
+	}
+
+	candidatesJSON, _ := json.Marshal(candidates)
+	abiParam := []string{METHOD_TDPOS_VOTE, string(candidatesJSON), amount}
+
+	return decoder.createStakingRawTransaction(wrapper, account, desc, abiParam, voteAmount)
+}
+
+//voteCandidates 检查投票的候选人地址，重复的地址只保留第一个
+func voteCandidates(candidates []string, addrVerify func(address string, opts ...interface{}) bool) ([]string, error) {
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("vote candidates is empty")
+	}
+
+	list := make([]string, 0, len(candidates))
+	exist := make(map[string]bool)
+	for _, candidate := range candidates {
+		if !addrVerify(candidate) {
+			return nil, fmt.Errorf("candidate: %s is invalid", candidate)
+		}
+		if exist[candidate] {
+			continue
+		}
+		exist[candidate] = true
+		list = append(list, candidate)
+	}
+
+	return list, nil
+}
+
+//CreateRevokeVoteRawTransaction 创建撤销投票的原始交易单，txid为投票交易
+func (decoder *ContractDecoder) CreateRevokeVoteRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, voteTxID string) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotFound, "assets account is empty")
+	}
+
+	defAddress, getErr := decoder.GetAssetsAccountDefAddress(wrapper, account.AccountID)
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	records, err := decoder.wm.RPC.DposVoteRecords(defAddress.Address)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	found := false
+	for _, r := range records {
+		if r.GetTxid() == voteTxID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "address: %s has no vote tx: %s", defAddress.Address, voteTxID)
+	}
+
+	desc := &StakingDesc{
+		Module: MODULE_TDPOS,
+		Method: METHOD_REVOKE_VOTE,
+		Args:   map[string]interface{}{"txid": voteTxID},
+	}
+
+	abiParam := []string{METHOD_REVOKE_VOTE, voteTxID}
+
+	return decoder.createStakingRawTransaction(wrapper, account, desc, abiParam, big.NewInt(0))
+}
+
+//CreateRevokeNominateRawTransaction 创建撤销提名的原始交易单，txid为提名交易
+func (decoder *ContractDecoder) CreateRevokeNominateRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, nominateTxID string) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotFound, "assets account is empty")
+	}
+
+	defAddress, getErr := decoder.GetAssetsAccountDefAddress(wrapper, account.AccountID)
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	records, err := decoder.wm.RPC.DposNominateRecords(defAddress.Address)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	found := false
+	for _, r := range records {
+		if r.GetTxid() == nominateTxID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, "address: %s has no nominate tx: %s", defAddress.Address, nominateTxID)
+	}
+
+	desc := &StakingDesc{
+		Module: MODULE_TDPOS,
+		Method: METHOD_REVOKE_CANDIDATE,
+		Args:   map[string]interface{}{"txid": nominateTxID},
+	}
+
+	abiParam := []string{METHOD_REVOKE_CANDIDATE, nominateTxID}
+
+	return decoder.createStakingRawTransaction(wrapper, account, desc, abiParam, big.NewInt(0))
+}
+
+//createStakingRawTransaction 创建TDPOS操作的原始交易单，冻结的金额转给发起者自己
+func (decoder *ContractDecoder) createStakingRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, desc *StakingDesc, abiParam []string, amount *big.Int, authAddrs ...*openwallet.Address) (*openwallet.SmartContractRawTransaction, *openwallet.Error) {
+
+	if account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotFound, "assets account is empty")
+	}
+
+	descJSON, err := json.Marshal(desc)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+
+	return decoder.createDescRawTransaction(wrapper, account, TDPOSContractAddress, descJSON, abiParam, amount, TDPOS_FROZEN_HEIGHT_FOREVER, authAddrs...)
+}
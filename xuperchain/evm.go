@@ -0,0 +1,256 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/golang/protobuf/proto"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+const (
+	contractEventBucket = "$transient"    //合约事件所在的bucket
+	contractEventKey    = "contractEvent" //合约事件的key
+)
+
+//contractEvent 合约原生事件，与链上pb.ContractEvent的编码一致
+type contractEvent struct {
+	Contract string `protobuf:"bytes,1,opt,name=contract,proto3" json:"contract,omitempty"`
+	Name     string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Body     []byte `protobuf:"bytes,3,opt,name=body,proto3" json:"body,omitempty"`
+}
+
+func (m *contractEvent) Reset()         { *m = contractEvent{} }
+func (m *contractEvent) String() string { return proto.CompactTextString(m) }
+func (*contractEvent) ProtoMessage()    {}
+
+//evmLog evm合约日志，记录在合约事件的Body中
+type evmLog struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
+//parseContractEvents 解析交易中的合约原生事件，事件按长度前缀依次编码
+func parseContractEvents(trx *pb.Transaction) ([]*contractEvent, error) {
+
+	events := make([]*contractEvent, 0)
+
+	for _, outPutExt := range trx.GetTxOutputsExt() {
+		if outPutExt.GetBucket() != contractEventBucket || string(outPutExt.GetKey()) != contractEventKey {
+			continue
+		}
+
+		buf := proto.NewBuffer(outPutExt.GetValue())
+		for {
+			msgBytes, err := buf.DecodeRawBytes(false)
+			if err != nil {
+				break
+			}
+			event := &contractEvent{}
+			if err = proto.Unmarshal(msgBytes, event); err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+//encodeEVMInput 使用solidity abi编码evm合约调用参数，包含方法签名
+func encodeEVMInput(abiInstance abi.ABI, abiMethod abi.Method, abiArgs []string) ([]byte, error) {
+
+	values := make([]interface{}, 0, len(abiArgs))
+	for i, input := range abiMethod.Inputs {
+		name := abiArgumentName(input, i)
+		raw, err := parseABIParam(input.Type, abiArgs[i])
+		if err != nil {
+			return nil, fmt.Errorf("abi argument %s is invalid: %v", name, err)
+		}
+		value, err := packABIValue(input.Type, raw, name)
+		if err != nil {
+			return nil, fmt.Errorf("abi argument %v", err)
+		}
+		values = append(values, value)
+	}
+
+	return abiInstance.Pack(abiMethod.Name, values...)
+}
+
+//numBase 数字字符串的进制
+func numBase(param string) int {
+	if strings.HasPrefix(param, "0x") {
+		return 16
+	}
+	return 10
+}
+
+//decodeEVMOutput 使用solidity abi解码evm合约的返回值
+func decodeEVMOutput(abiMethod abi.Method, data []byte) (map[string]interface{}, error) {
+
+	if len(abiMethod.Outputs) == 0 || len(data) == 0 {
+		return nil, nil
+	}
+
+	values, err := abiMethod.Outputs.UnpackValues(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	for i, output := range abiMethod.Outputs {
+		result[abiArgumentName(output, i)] = formatEVMValue(values[i])
+	}
+
+	return result, nil
+}
+
+//decodeEVMLog 按abi事件定义解码evm日志，返回事件名和json值
+func decodeEVMLog(abiInstance abi.ABI, body []byte) (string, string, error) {
+
+	var log evmLog
+	if err := json.Unmarshal(body, &log); err != nil {
+		return "", "", err
+	}
+
+	if len(log.Topics) == 0 {
+		return "", "", fmt.Errorf("evm log topics is empty")
+	}
+
+	topics := make([]ethcom.Hash, 0, len(log.Topics))
+	for _, topic := range log.Topics {
+		topics = append(topics, ethcom.HexToHash(topic))
+	}
+
+	for _, event := range abiInstance.Events {
+
+		eventID := event.ID()
+		if !bytes.Equal(eventID.Bytes(), topics[0].Bytes()) {
+			continue
+		}
+
+		result := make(map[string]interface{})
+
+		//非索引参数在data中
+		data, err := hex.DecodeString(strings.TrimPrefix(log.Data, "0x"))
+		if err != nil {
+			return "", "", err
+		}
+		nonIndexed := event.Inputs.NonIndexed()
+		if len(nonIndexed) > 0 {
+			values, unpackErr := nonIndexed.UnpackValues(data)
+			if unpackErr != nil {
+				return "", "", unpackErr
+			}
+			for i, input := range nonIndexed {
+				result[abiArgumentName(input, i)] = formatEVMValue(values[i])
+			}
+		}
+
+		//索引参数在topics中
+		topicIndex := 1
+		for i, input := range event.Inputs {
+			if !input.Indexed {
+				continue
+			}
+			if topicIndex >= len(topics) {
+				return "", "", fmt.Errorf("evm log topics is not enough")
+			}
+			result[abiArgumentName(input, i)] = decodeEVMTopic(input.Type, topics[topicIndex])
+			topicIndex++
+		}
+
+		valueJSON, err := json.Marshal(result)
+		if err != nil {
+			return "", "", err
+		}
+
+		return event.Name, string(valueJSON), nil
+	}
+
+	return "", "", fmt.Errorf("evm log event can not found in abi")
+}
+
+//decodeEVMTopic 解码索引参数，动态类型只能得到其哈希值
+func decodeEVMTopic(t abi.Type, topic ethcom.Hash) interface{} {
+	switch t.T {
+	case abi.AddressTy:
+		return ethcom.BytesToAddress(topic.Bytes()).Hex()
+	case abi.BoolTy:
+		return topic[ethcom.HashLength-1] == 1
+	case abi.UintTy:
+		return new(big.Int).SetBytes(topic.Bytes()).String()
+	case abi.IntTy:
+		return math.S256(new(big.Int).SetBytes(topic.Bytes())).String()
+	}
+	return topic.Hex()
+}
+
+//formatEVMValue 转化abi解码值为可读的json值
+func formatEVMValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *big.Int:
+		return v.String()
+	case ethcom.Address:
+		return v.Hex()
+	case []byte:
+		return hex.EncodeToString(v)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(rv.Int()).String()
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(rv.Uint()).String()
+	case reflect.Array:
+		//定长bytes
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			for i := 0; i < rv.Len(); i++ {
+				b[i] = byte(rv.Index(i).Uint())
+			}
+			return hex.EncodeToString(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		list := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			list = append(list, formatEVMValue(rv.Index(i).Interface()))
+		}
+		return list
+	}
+
+	return value
+}
+
+//abiArgumentName 参数名，没有命名的参数使用序号
+func abiArgumentName(arg abi.Argument, index int) string {
+	if len(arg.Name) > 0 {
+		return arg.Name
+	}
+	return fmt.Sprintf("%d", index)
+}
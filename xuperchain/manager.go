@@ -15,16 +15,14 @@
 package xuperchain
 
 import (
-	"encoding/hex"
 	"fmt"
-	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/blocktree/xuperchain-adapter/xuperchain_addrdec"
 	"github.com/blocktree/xuperchain-adapter/xuperchain_rpc"
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
 	"github.com/xuperchain/xuperchain/core/pb"
-	"math/big"
 	"strings"
 )
 
@@ -37,21 +35,45 @@ type WalletManager struct {
 	AddrDecoder     openwallet.AddressDecoderV2   //地址编码器
 	TxDecoder       openwallet.TransactionDecoder //交易单编码器
 	ContractDecoder *ContractDecoder              //智能合约解释器
+	ReceiptWaiter   *ReceiptWaiter                //交易回执等待器
 	Log             *log.OWLogger                 //日志工具
+
+	chains []*WalletManager //平行链的钱包管理者
 }
 
 func NewWalletManager() *WalletManager {
+	return newWalletManager(Symbol)
+}
+
+//newWalletManager 创建指定币种的钱包管理者，平行链使用各自的币种
+func newWalletManager(symbol string) *WalletManager {
 	wm := WalletManager{}
-	wm.Config = NewConfig(Symbol)
+	wm.Config = NewConfig(symbol)
 	wm.BlockScanner = NewBlockScanner(&wm)
 	wm.AddrDecoder = xuperchain_addrdec.NewAddressDecoder(wm.CurveType())
 	wm.TxDecoder = NewTransactionDecoder(&wm)
 	wm.ContractDecoder = &ContractDecoder{wm: &wm}
+	wm.ReceiptWaiter = NewReceiptWaiter(&wm)
 	wm.Log = log.NewOWLogger(wm.Symbol())
 
 	return &wm
 }
 
+//EVMAddress 地址对应的evm合约地址，即地址中的hash160，已是evm地址时直接返回
+func (wm *WalletManager) EVMAddress(address string) (string, error) {
+
+	if ethcom.IsHexAddress(address) {
+		return ethcom.HexToAddress(address).Hex(), nil
+	}
+
+	hash, err := wm.AddrDecoder.AddressDecode(address)
+	if err != nil || len(hash) != ethcom.AddressLength {
+		return "", fmt.Errorf("address: %s can not convert to evm address", address)
+	}
+
+	return ethcom.BytesToAddress(hash).Hex(), nil
+}
+
 // EncodeInvokeRequest 编码API调用参数
 func (wm *WalletManager) EncodeInvokeRequest(abiInstance abi.ABI, contractAddress string, abiParam ...string) (*pb.InvokeRequest, error) {
 
@@ -61,10 +83,7 @@ func (wm *WalletManager) EncodeInvokeRequest(abiInstance abi.ABI, contractAddres
 		contractName = ""
 	)
 
-	//拆分合约地址
-	contractInfo := strings.Split(contractAddress, ":")
-
-	if len(contractInfo) == 0 {
+	if len(contractAddress) == 0 {
 		return nil, fmt.Errorf("contract address is invalid")
 	}
 
@@ -72,12 +91,8 @@ func (wm *WalletManager) EncodeInvokeRequest(abiInstance abi.ABI, contractAddres
 		return nil, fmt.Errorf("abi param length is empty")
 	}
 
-	if len(contractInfo) == 2 {
-		moduleName = contractInfo[0]
-		contractName = contractInfo[1]
-	} else {
-		moduleName = contractInfo[0]
-	}
+	//拆分合约地址
+	moduleName, contractName = splitContractAddress(contractAddress)
 
 	method := abiParam[0]
 	//转化string参数为abi调用参数
@@ -89,24 +104,35 @@ func (wm *WalletManager) EncodeInvokeRequest(abiInstance abi.ABI, contractAddres
 	if len(abiMethod.Inputs) != len(abiArgs) {
 		return nil, fmt.Errorf("abi input arguments is: %d, except is : %d", len(abiArgs), len(abiMethod.Inputs))
 	}
+
+	//evm合约使用solidity abi编码参数
+	if moduleName == MODULE_EVM {
+		input, err := encodeEVMInput(abiInstance, abiMethod, abiArgs)
+		if err != nil {
+			return nil, err
+		}
+		args[EVM_ARG_INPUT] = input
+		args[EVM_ARG_JSON_ENCODED] = []byte("false")
+
+		return &pb.InvokeRequest{
+			ModuleName:   moduleName,
+			MethodName:   method,
+			ContractName: contractName,
+			Args:         args,
+		}, nil
+	}
+
 	for i, input := range abiMethod.Inputs {
 
-		var a []byte
-		switch input.Type.T {
-		case abi.BoolTy:
-			if abiArgs[i] == "true" {
-				a = []byte{0x01}
-			} else {
-				a = []byte{0x00}
-			}
-		case abi.UintTy, abi.IntTy:
-			a, _ = convertParamToNum(abiArgs[i])
-		case abi.AddressTy:
-			a = []byte(abiArgs[i])
-		case abi.FixedBytesTy, abi.BytesTy, abi.HashTy:
-			a, _ = hex.DecodeString(abiArgs[i])
-		case abi.StringTy:
-			a = []byte(abiArgs[i])
+		name := abiArgumentName(input, i)
+		raw, err := parseABIParam(input.Type, abiArgs[i])
+		if err != nil {
+			return nil, fmt.Errorf("abi argument %s is invalid: %v", name, err)
+		}
+
+		a, err := encodeWasmValue(input.Type, raw, name)
+		if err != nil {
+			return nil, fmt.Errorf("abi argument %v", err)
 		}
 
 		args[input.Name] = a
@@ -122,22 +148,11 @@ func (wm *WalletManager) EncodeInvokeRequest(abiInstance abi.ABI, contractAddres
 	return invokeRequest, nil
 }
 
-
-func convertParamToNum(param string) ([]byte, error) {
-	var (
-		base int
-		bInt *big.Int
-		err  error
-	)
-	if strings.HasPrefix(param, "0x") {
-		base = 16
-	} else {
-		base = 10
-	}
-	bInt, err = common.StringValueToBigInt(param, base)
-	if err != nil {
-		return nil, err
+//splitContractAddress 拆分合约地址为模块名和合约名，格式为module:contract
+func splitContractAddress(contractAddress string) (string, string) {
+	contractInfo := strings.SplitN(contractAddress, ":", 2)
+	if len(contractInfo) == 2 {
+		return contractInfo[0], contractInfo[1]
 	}
-
-	return bInt.Bytes(), nil
-}
\ No newline at end of file
+	return contractInfo[0], ""
+}
@@ -18,7 +18,9 @@ package xuperchain
 import (
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/log"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -53,4 +55,114 @@ func TestClient_GetBalance(t *testing.T) {
 		return
 	}
 	log.Infof("balance: %+v", balances)
-}
\ No newline at end of file
+}
+
+func TestWalletManager_EncodeInvokeRequest(t *testing.T) {
+	abiJSON := `[{"inputs":[{"name":"to","type":"address[]"},{"name":"amounts","type":"uint8[2]"},{"components":[{"name":"id","type":"int16"},{"name":"memo","type":"bytes4"}],"name":"order","type":"tuple"}],"name":"batch","outputs":[],"type":"function"}]`
+	abiInstance, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		t.Errorf("abi.JSON failed, err: %v", err)
+		return
+	}
+
+	to := `["0x0000000000000000000000000000000000000001","0x0000000000000000000000000000000000000002"]`
+	order := `{"id":-7,"memo":"0x01020304"}`
+
+	for _, address := range []string{"wasm:batchContract", "evm:batchContract"} {
+		req, err := tw.EncodeInvokeRequest(abiInstance, address, "batch", to, `[1,255]`, order)
+		if err != nil {
+			t.Errorf("EncodeInvokeRequest failed, err: %v", err)
+			return
+		}
+		log.Infof("%s args: %+v", address, req.Args)
+	}
+
+	_, err = tw.EncodeInvokeRequest(abiInstance, "evm:batchContract", "batch", to, `[1,256]`, order)
+	if err == nil {
+		t.Errorf("EncodeInvokeRequest should failed with out of range amount")
+		return
+	}
+	log.Infof("expected error: %v", err)
+}
+
+func TestWalletManager_LoadParallelChains(t *testing.T) {
+	ini := `
+serverAPI = 127.0.0.1:37101
+chainName = xuper
+confirmDepth = 6
+parallelChains = chainA,chainB
+
+[chainA]
+symbol = XUPERA
+
+[chainB]
+symbol = XUPERB
+chainName = bchain
+serverAPI = 127.0.0.1:37102
+confirmDepth = 1
+`
+	c, err := config.NewConfigData("ini", []byte(ini))
+	if err != nil {
+		t.Errorf("NewConfigData failed, err: %v", err)
+		return
+	}
+
+	wm := NewWalletManager()
+	err = wm.LoadAssetsConfig(c)
+	if err != nil {
+		t.Errorf("LoadAssetsConfig failed, err: %v", err)
+		return
+	}
+
+	if names := strings.Join(wm.ChainNames(), ","); names != "xuper,chainA,bchain" {
+		t.Errorf("unexpected chain names: %s", names)
+	}
+
+	chainA, err := wm.Chain("chainA")
+	if err != nil {
+		t.Errorf("Chain failed, err: %v", err)
+		return
+	}
+	if chainA.Symbol() != "XUPERA" || chainA.Config.ServerAPI != "127.0.0.1:37101" || chainA.Config.ConfirmDepth != 6 {
+		t.Errorf("chainA should inherit main config: %+v", chainA.Config)
+	}
+
+	chainB, err := wm.Chain("bchain")
+	if err != nil {
+		t.Errorf("Chain failed, err: %v", err)
+		return
+	}
+	if chainB.RPC.ChainName != "bchain" || chainB.Config.ServerAPI != "127.0.0.1:37102" || chainB.Config.ConfirmDepth != 1 {
+		t.Errorf("chainB should use its section config: %+v", chainB.Config)
+	}
+
+	if chain, _ := wm.chainBySymbol("XUPERB"); chain != chainB {
+		t.Errorf("chainBySymbol routed to wrong chain")
+	}
+	if chain, _ := wm.chainBySymbol(Symbol); chain != wm {
+		t.Errorf("chainBySymbol routed to wrong chain")
+	}
+	if _, err = wm.chainBySymbol("UNKNOWN"); err == nil {
+		t.Errorf("unknown symbol should return error")
+	}
+
+	scanner, err := wm.BlockScanner.(*BlockScanner).Chain("bchain")
+	if err != nil || scanner != chainB.BlockScanner {
+		t.Errorf("block scanner Chain routed to wrong chain, err: %v", err)
+	}
+	if len(wm.BlockScanner.(*BlockScanner).chainBlockScanners()) != 3 {
+		t.Errorf("block scanners should include parallel chains")
+	}
+
+	decoder, err := wm.GetSmartContractDecoder().(*chainContractDecoder).Chain("chainA")
+	if err != nil || decoder != chainA.ContractDecoder {
+		t.Errorf("contract decoder Chain routed to wrong chain, err: %v", err)
+	}
+	if _, err = wm.GetSmartContractDecoder().(*chainContractDecoder).Chain("unknown"); err == nil {
+		t.Errorf("unknown chain should return error")
+	}
+
+	if _, err = wm.Chain("unknown"); err == nil {
+		t.Errorf("unknown chain should return error")
+	}
+}
@@ -0,0 +1,326 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//chainSectionConfig 平行链的配置，优先读取链名section中的配置项，未配置时使用主链的配置
+type chainSectionConfig struct {
+	config.Configer
+	section string
+}
+
+//key 配置项的完整key
+func (c *chainSectionConfig) key(key string) string {
+	sectionKey := c.section + "::" + key
+	if len(c.Configer.String(sectionKey)) > 0 {
+		return sectionKey
+	}
+	return key
+}
+
+func (c *chainSectionConfig) String(key string) string {
+	return c.Configer.String(c.key(key))
+}
+
+func (c *chainSectionConfig) DefaultString(key string, defaultVal string) string {
+	return c.Configer.DefaultString(c.key(key), defaultVal)
+}
+
+func (c *chainSectionConfig) DefaultInt(key string, defaultVal int) int {
+	return c.Configer.DefaultInt(c.key(key), defaultVal)
+}
+
+func (c *chainSectionConfig) DefaultInt64(key string, defaultVal int64) int64 {
+	return c.Configer.DefaultInt64(c.key(key), defaultVal)
+}
+
+func (c *chainSectionConfig) DefaultBool(key string, defaultVal bool) bool {
+	return c.Configer.DefaultBool(c.key(key), defaultVal)
+}
+
+//loadParallelChains 创建平行链的钱包管理者，每条链使用独立的币种、扫描高度和节点客户端
+func (wm *WalletManager) loadParallelChains(c config.Configer) error {
+
+	wm.chains = make([]*WalletManager, 0, len(wm.Config.ParallelChains))
+	symbols := map[string]bool{wm.Symbol(): true}
+	chainNames := map[string]bool{wm.Config.ChainName: true}
+
+	for _, section := range wm.Config.ParallelChains {
+
+		//币种用于区分扫描高度和交易记录，必须单独配置
+		symbol := c.String(section + "::symbol")
+		if len(symbol) == 0 {
+			return fmt.Errorf("parallel chain: %s symbol is empty", section)
+		}
+		if symbols[symbol] {
+			return fmt.Errorf("parallel chain: %s symbol: %s is duplicated", section, symbol)
+		}
+
+		chain := newWalletManager(symbol)
+		err := chain.loadChainConfig(&chainSectionConfig{Configer: c, section: section})
+		if err != nil {
+			return fmt.Errorf("parallel chain: %s config is invalid, err: %v", section, err)
+		}
+
+		//链名默认为section名
+		chain.Config.ChainName = c.DefaultString(section+"::chainName", section)
+		if chainNames[chain.Config.ChainName] {
+			return fmt.Errorf("parallel chain: %s chain name: %s is duplicated", section, chain.Config.ChainName)
+		}
+		chain.RPC.ChainName = chain.Config.ChainName
+
+		symbols[symbol] = true
+		chainNames[chain.Config.ChainName] = true
+		wm.chains = append(wm.chains, chain)
+	}
+
+	return nil
+}
+
+//Chain 获取链名对应的钱包管理者，主链返回自身
+func (wm *WalletManager) Chain(chainName string) (*WalletManager, error) {
+	if chainName == wm.Config.ChainName {
+		return wm, nil
+	}
+	for _, chain := range wm.chains {
+		if chain.Config.ChainName == chainName {
+			return chain, nil
+		}
+	}
+	return nil, fmt.Errorf("chain: %s is not configured", chainName)
+}
+
+//ChainNames 主链及平行链的链名
+func (wm *WalletManager) ChainNames() []string {
+	names := []string{wm.Config.ChainName}
+	for _, chain := range wm.chains {
+		names = append(names, chain.Config.ChainName)
+	}
+	return names
+}
+
+//chainBySymbol 币种对应的钱包管理者，未配置的币种返回错误，避免广播到错误的链
+func (wm *WalletManager) chainBySymbol(symbol string) (*WalletManager, error) {
+	if symbol == wm.Symbol() {
+		return wm, nil
+	}
+	for _, chain := range wm.chains {
+		if chain.Symbol() == symbol {
+			return chain, nil
+		}
+	}
+	return nil, fmt.Errorf("symbol: %s is not configured on any chain", symbol)
+}
+
+//CheckParallelChains 检查配置的平行链是否都已在节点上创建
+func (wm *WalletManager) CheckParallelChains() error {
+
+	if len(wm.chains) == 0 {
+		return nil
+	}
+
+	names, err := wm.RPC.GetBlockChains()
+	if err != nil {
+		return err
+	}
+
+	exist := make(map[string]bool)
+	for _, name := range names {
+		exist[name] = true
+	}
+
+	for _, chain := range wm.chains {
+		if !exist[chain.Config.ChainName] {
+			return fmt.Errorf("chain: %s does not exist on node", chain.Config.ChainName)
+		}
+	}
+
+	return nil
+}
+
+//Chain 获取链名对应的区块扫描器，主链返回自身
+func (bs *BlockScanner) Chain(chainName string) (*BlockScanner, error) {
+	chain, err := bs.wm.Chain(chainName)
+	if err != nil {
+		return nil, err
+	}
+	return chain.BlockScanner.(*BlockScanner), nil
+}
+
+//chainBlockScanners 主链及平行链的区块扫描器，主链在前
+func (bs *BlockScanner) chainBlockScanners() []*BlockScanner {
+	scanners := []*BlockScanner{bs}
+	for _, chain := range bs.wm.chains {
+		scanners = append(scanners, chain.BlockScanner.(*BlockScanner))
+	}
+	return scanners
+}
+
+//ExtractTransactionData 提取交易单数据，主链查询不到的交易依次从平行链查询
+func (bs *BlockScanner) ExtractTransactionData(txid string, scanTargetFunc openwallet.BlockScanTargetFunc) (map[string][]*openwallet.TxExtractData, error) {
+	var firstErr error
+	for _, scanner := range bs.chainBlockScanners() {
+		extData, err := scanner.extractTransactionData(txid, scanTargetFunc)
+		if err == nil {
+			return extData, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+//ExtractTransactionAndReceiptData 提取交易单及交易回执数据，主链查询不到的交易依次从平行链查询
+//@required
+func (bs *BlockScanner) ExtractTransactionAndReceiptData(txid string, scanTargetFunc openwallet.BlockScanTargetFuncV2) (map[string][]*openwallet.TxExtractData, map[string]*openwallet.SmartContractReceipt, error) {
+	var firstErr error
+	for _, scanner := range bs.chainBlockScanners() {
+		extData, receipts, err := scanner.extractTransactionAndReceiptData(txid, scanTargetFunc)
+		if err == nil {
+			return extData, receipts, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, nil, firstErr
+}
+
+//chainTransactionDecoder 按交易单的币种路由到对应链的交易单解析器
+type chainTransactionDecoder struct {
+	openwallet.TransactionDecoder
+	wm *WalletManager
+}
+
+//Chain 获取链名对应的交易单解析器
+func (decoder *chainTransactionDecoder) Chain(chainName string) (openwallet.TransactionDecoder, error) {
+	chain, err := decoder.wm.Chain(chainName)
+	if err != nil {
+		return nil, err
+	}
+	return chain.TxDecoder, nil
+}
+
+//CreateRawTransaction 创建交易单
+func (decoder *chainTransactionDecoder) CreateRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	chain, err := decoder.wm.chainBySymbol(rawTx.Coin.Symbol)
+	if err != nil {
+		return err
+	}
+	return chain.TxDecoder.CreateRawTransaction(wrapper, rawTx)
+}
+
+//CreateSummaryRawTransactionWithError 创建汇总交易
+func (decoder *chainTransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
+	chain, err := decoder.wm.chainBySymbol(sumRawTx.Coin.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	return chain.TxDecoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
+}
+
+//CreateSummaryRawTransaction 创建汇总交易
+func (decoder *chainTransactionDecoder) CreateSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransaction, error) {
+	chain, err := decoder.wm.chainBySymbol(sumRawTx.Coin.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	return chain.TxDecoder.CreateSummaryRawTransaction(wrapper, sumRawTx)
+}
+
+//SignRawTransaction 签名交易单
+func (decoder *chainTransactionDecoder) SignRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	chain, err := decoder.wm.chainBySymbol(rawTx.Coin.Symbol)
+	if err != nil {
+		return err
+	}
+	return chain.TxDecoder.SignRawTransaction(wrapper, rawTx)
+}
+
+//VerifyRawTransaction 验证交易单
+func (decoder *chainTransactionDecoder) VerifyRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	chain, err := decoder.wm.chainBySymbol(rawTx.Coin.Symbol)
+	if err != nil {
+		return err
+	}
+	return chain.TxDecoder.VerifyRawTransaction(wrapper, rawTx)
+}
+
+//SubmitRawTransaction 广播交易单
+func (decoder *chainTransactionDecoder) SubmitRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*openwallet.Transaction, error) {
+	chain, err := decoder.wm.chainBySymbol(rawTx.Coin.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	return chain.TxDecoder.SubmitRawTransaction(wrapper, rawTx)
+}
+
+//chainContractDecoder 按合约的币种路由到对应链的智能合约解析器
+type chainContractDecoder struct {
+	openwallet.SmartContractDecoder
+	wm *WalletManager
+}
+
+//Chain 获取链名对应的智能合约解析器
+func (decoder *chainContractDecoder) Chain(chainName string) (*ContractDecoder, error) {
+	chain, err := decoder.wm.Chain(chainName)
+	if err != nil {
+		return nil, err
+	}
+	return chain.ContractDecoder, nil
+}
+
+//GetTokenBalanceByAddress 查询地址的代币余额
+func (decoder *chainContractDecoder) GetTokenBalanceByAddress(contract openwallet.SmartContract, address ...string) ([]*openwallet.TokenBalance, error) {
+	chain, err := decoder.wm.chainBySymbol(contract.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	return chain.ContractDecoder.GetTokenBalanceByAddress(contract, address...)
+}
+
+//CallSmartContractABI 调用合约ABI方法
+func (decoder *chainContractDecoder) CallSmartContractABI(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractCallResult, *openwallet.Error) {
+	chain, err := decoder.wm.chainBySymbol(rawTx.Coin.Symbol)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrContractCallMsgInvalid, err.Error())
+	}
+	return chain.ContractDecoder.CallSmartContractABI(wrapper, rawTx)
+}
+
+//CreateSmartContractRawTransaction 创建合约调用的原始交易单
+func (decoder *chainContractDecoder) CreateSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) *openwallet.Error {
+	chain, err := decoder.wm.chainBySymbol(rawTx.Coin.Symbol)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawSmartContractTransactionFailed, err.Error())
+	}
+	return chain.ContractDecoder.CreateSmartContractRawTransaction(wrapper, rawTx)
+}
+
+//SubmitSmartContractRawTransaction 广播合约调用的交易单
+func (decoder *chainContractDecoder) SubmitSmartContractRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.SmartContractRawTransaction) (*openwallet.SmartContractReceipt, *openwallet.Error) {
+	chain, err := decoder.wm.chainBySymbol(rawTx.Coin.Symbol)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSubmitRawSmartContractTransactionFailed, err.Error())
+	}
+	return chain.ContractDecoder.SubmitSmartContractRawTransaction(wrapper, rawTx)
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xuperchain
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/xuperchain/xuperchain/core/pb"
+	"sync"
+	"time"
+)
+
+const (
+	//TxStatusPending 等待超时仍未出块的交易回执状态
+	TxStatusPending = "pending"
+
+	//receiptPollInterval 没有新区块通知时，共享轮询的间隔
+	receiptPollInterval = 2 * time.Second
+)
+
+//pendingReceipt 等待回执的交易
+type pendingReceipt struct {
+	txid           string
+	contractID     string
+	scanTargetFunc openwallet.BlockScanTargetFuncV2
+	done           chan *openwallet.SmartContractReceipt
+	receipt        *openwallet.SmartContractReceipt //提交的回执，由Desc执行的交易以此生成回执
+	descTx         bool                             //是否由Desc执行的系统交易，没有合约调用
+}
+
+//ReceiptWaiter 交易回执等待器，所有等待的交易共用一个轮询线程
+//收到新区块通知时立即检查，没有通知时按间隔轮询
+type ReceiptWaiter struct {
+	wm       *WalletManager
+	pending  map[*pendingReceipt]bool
+	mu       sync.Mutex
+	trigger  chan struct{}
+	interval time.Duration
+	running  bool
+}
+
+//NewReceiptWaiter 创建交易回执等待器
+func NewReceiptWaiter(wm *WalletManager) *ReceiptWaiter {
+	return &ReceiptWaiter{
+		wm:       wm,
+		pending:  make(map[*pendingReceipt]bool),
+		trigger:  make(chan struct{}, 1),
+		interval: receiptPollInterval,
+	}
+}
+
+//NotifyNewBlock 新区块通知，唤醒轮询线程检查等待的交易
+func (w *ReceiptWaiter) NotifyNewBlock() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+		//已有未处理的通知
+	}
+}
+
+//Wait 等待交易的合约回执，ctx取消时返回错误，超时返回状态为pending的回执
+//提案、投票及TDPOS等由Desc执行的交易没有合约调用，交易上链后返回交易的回执
+func (w *ReceiptWaiter) Wait(ctx context.Context, timeout time.Duration, receipt *openwallet.SmartContractReceipt, contract *openwallet.SmartContract) (*openwallet.SmartContractReceipt, error) {
+
+	if contract == nil {
+		return nil, fmt.Errorf("contract is nil")
+	}
+
+	addrs := map[string]openwallet.ScanTargetResult{
+		contract.Address: {SourceKey: contract.ContractID, Exist: true, TargetInfo: contract},
+	}
+
+	p := &pendingReceipt{
+		txid:       receipt.TxID,
+		contractID: contract.ContractID,
+		scanTargetFunc: func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+			result := addrs[target.ScanTarget]
+			if result.Exist {
+				return result
+			}
+			return openwallet.ScanTargetResult{SourceKey: "", Exist: false, TargetInfo: nil}
+		},
+		done:    make(chan *openwallet.SmartContractReceipt, 1),
+		receipt: receipt,
+		descTx:  isDescContractAddress(contract.Address),
+	}
+
+	w.add(p)
+	defer w.remove(p)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-p.done:
+		return result, nil
+	case <-ctx.Done():
+		return pendingReceiptOf(receipt, fmt.Sprintf("wait transaction receipt canceled: %v", ctx.Err())), ctx.Err()
+	case <-timer.C:
+		return pendingReceiptOf(receipt, fmt.Sprintf("transaction is not confirmed in %v", timeout)), nil
+	}
+}
+
+//pendingReceiptOf 未等到结果的回执，状态为pending并记录原因
+func pendingReceiptOf(receipt *openwallet.SmartContractReceipt, reason string) *openwallet.SmartContractReceipt {
+	pending := *receipt
+	pending.Status = TxStatusPending
+	pending.Reason = reason
+	return &pending
+}
+
+//add 添加等待的交易，需要时启动轮询线程
+func (w *ReceiptWaiter) add(p *pendingReceipt) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending[p] = true
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+//remove 删除等待的交易
+func (w *ReceiptWaiter) remove(p *pendingReceipt) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.pending, p)
+}
+
+//run 共享的轮询线程，没有等待的交易时退出
+func (w *ReceiptWaiter) run() {
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.trigger:
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		if len(w.pending) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		list := make([]*pendingReceipt, 0, len(w.pending))
+		for p := range w.pending {
+			list = append(list, p)
+		}
+		w.mu.Unlock()
+
+		w.check(list)
+	}
+}
+
+//check 检查等待的交易是否已出块，提取失败的交易等待下次检查
+func (w *ReceiptWaiter) check(list []*pendingReceipt) {
+
+	bs := w.wm.GetBlockScanner()
+	if bs == nil {
+		return
+	}
+
+	for _, p := range list {
+
+		if p.descTx {
+			if receipt := w.descTxReceipt(p); receipt != nil {
+				w.finish(p, receipt)
+			}
+			continue
+		}
+
+		_, contractResult, err := bs.ExtractTransactionAndReceiptData(p.txid, p.scanTargetFunc)
+		if err != nil {
+			w.wm.Log.Debugf("extract transaction: %s receipt failed, err: %v", p.txid, err)
+			continue
+		}
+
+		//未确认的交易没有区块高度
+		receipt := contractResult[p.contractID]
+		if receipt == nil || receipt.BlockHeight == 0 {
+			continue
+		}
+
+		w.finish(p, receipt)
+	}
+}
+
+//finish 返回交易的回执，并删除等待的交易
+func (w *ReceiptWaiter) finish(p *pendingReceipt, receipt *openwallet.SmartContractReceipt) {
+	select {
+	case p.done <- receipt:
+	default:
+	}
+	w.remove(p)
+}
+
+//descTxReceipt 由Desc执行的交易上链或分叉后，按交易状态和所在区块生成回执，未上链时返回nil
+func (w *ReceiptWaiter) descTxReceipt(p *pendingReceipt) *openwallet.SmartContractReceipt {
+
+	tx, err := w.wm.RPC.QueryTx(p.txid)
+	if err != nil || tx.GetTx() == nil {
+		w.wm.Log.Debugf("query transaction: %s failed, err: %v", p.txid, err)
+		return nil
+	}
+
+	var block *pb.InternalBlock
+	if tx.GetStatus() == pb.TransactionStatus_CONFIRM && len(tx.GetTx().GetBlockid()) > 0 {
+		block, err = w.wm.RPC.GetBlock(hex.EncodeToString(tx.GetTx().GetBlockid()))
+		if err != nil {
+			w.wm.Log.Debugf("get transaction: %s block failed, err: %v", p.txid, err)
+			return nil
+		}
+	}
+
+	return descTxReceipt(p.receipt, tx, block)
+}
+
+//descTxReceipt 由Desc执行的交易的回执，未出块返回nil，分叉的交易为失败状态
+func descTxReceipt(submitted *openwallet.SmartContractReceipt, tx *pb.TxStatus, block *pb.InternalBlock) *openwallet.SmartContractReceipt {
+
+	receipt := *submitted
+
+	if status, reason, ok := txChainStatus(tx); ok {
+		if status == TxStatusPending {
+			return nil
+		}
+		receipt.Status = status
+		receipt.Reason = reason
+		return &receipt
+	}
+
+	if block == nil {
+		return nil
+	}
+
+	receipt.BlockHash = hex.EncodeToString(block.GetBlockid())
+	receipt.BlockHeight = uint64(block.GetHeight())
+	receipt.ConfirmTime = blockConfirmTime(block, tx.GetTx())
+	receipt.Status, receipt.Reason = txExecutionStatus(block, tx.GetTx())
+
+	return &receipt
+}
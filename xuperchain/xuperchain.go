@@ -16,10 +16,13 @@
 package xuperchain
 
 import (
+	"fmt"
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/blocktree/xuperchain-adapter/xuperchain_rpc"
+	"github.com/shopspring/decimal"
+	"time"
 )
 
 //FullName 币种全名
@@ -47,8 +50,11 @@ func (wm *WalletManager) GetAddressDecoderV2() openwallet.AddressDecoderV2 {
 	return wm.AddrDecoder
 }
 
-//TransactionDecoder 交易单解析器
+//TransactionDecoder 交易单解析器，配置了平行链时按币种路由到对应链
 func (wm *WalletManager) GetTransactionDecoder() openwallet.TransactionDecoder {
+	if len(wm.chains) > 0 {
+		return &chainTransactionDecoder{TransactionDecoder: wm.TxDecoder, wm: wm}
+	}
 	return wm.TxDecoder
 }
 
@@ -59,8 +65,44 @@ func (wm *WalletManager) GetBlockScanner() openwallet.BlockScanner {
 
 //LoadAssetsConfig 加载外部配置
 func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
+	err := wm.loadChainConfig(c)
+	if err != nil {
+		return err
+	}
+	wm.Config.ParallelChains = parseParallelChains(c.String("parallelChains"))
+	return wm.loadParallelChains(c)
+}
+
+//loadChainConfig 加载一条链的配置
+func (wm *WalletManager) loadChainConfig(c config.Configer) error {
 	wm.Config.ServerAPI = c.String("serverAPI")
 	wm.Config.ChainName = c.String("chainName")
+	wm.Config.ConfirmDepth = uint64(c.DefaultInt64("confirmDepth", 0))
+	wm.Config.UseIrreversibleHeight = c.DefaultBool("useIrreversibleHeight", true)
+	wm.Config.PrefetchBlockSize = c.DefaultInt("prefetchBlockSize", 1)
+	wm.Config.TokenBalanceMethod = c.DefaultString("tokenBalanceMethod", "balanceOf")
+	wm.Config.ContractEventKeys = parseContractEventKeys(c.String("contractEventKeys"))
+	wm.Config.EnableStateDiff = c.DefaultBool("enableStateDiff", false)
+	wm.Config.StateDiffPrefixes = parseStateDiffPrefixes(c.String("stateDiffPrefixes"))
+	gasMultiplier, err := decimal.NewFromString(c.DefaultString("contractGasMultiplier", "1"))
+	if err != nil || gasMultiplier.LessThan(decimal.New(1, 0)) {
+		return fmt.Errorf("contractGasMultiplier must be a number not less than 1")
+	}
+	wm.Config.ContractGasMultiplier = gasMultiplier
+	wm.Config.ContractMaxFee, err = decimal.NewFromString(c.DefaultString("contractMaxFee", "0"))
+	if err != nil {
+		return fmt.Errorf("contractMaxFee is invalid, err: %v", err)
+	}
+	wm.Config.ContractMinFee, err = decimal.NewFromString(c.DefaultString("contractMinFee", "0"))
+	if err != nil {
+		return fmt.Errorf("contractMinFee is invalid, err: %v", err)
+	}
+	if err = validateFeeLimit(wm.Config.ContractMaxFee, wm.Config.ContractMinFee); err != nil {
+		return fmt.Errorf("contractMaxFee or contractMinFee is invalid, err: %v", err)
+	}
+	wm.Config.ContractFeePayer = c.String("contractFeePayer")
+	wm.Config.EnableEventSubscribe = c.DefaultBool("enableEventSubscribe", false)
+	wm.Config.SubscribeStaleTimeout = time.Duration(c.DefaultInt64("subscribeStaleTimeout", 60)) * time.Second
 	client := xuperchain_rpc.NewClient(wm.Config.ServerAPI, wm.Config.ChainName)
 	wm.RPC = client
 	return nil
@@ -76,8 +118,11 @@ func (wm *WalletManager) GetAssetsLogger() *log.OWLogger {
 	return wm.Log
 }
 
-//GetSmartContractDecoder 获取智能合约解析器
+//GetSmartContractDecoder 获取智能合约解析器，配置了平行链时按币种路由到对应链
 func (wm *WalletManager) GetSmartContractDecoder() openwallet.SmartContractDecoder {
+	if len(wm.chains) > 0 {
+		return &chainContractDecoder{SmartContractDecoder: wm.ContractDecoder, wm: wm}
+	}
 	return wm.ContractDecoder
 }
 
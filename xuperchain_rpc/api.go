@@ -28,6 +28,7 @@ import (
 type Client struct {
 	BaseURL      string
 	xchainClient pb.XchainClient
+	conn         *grpc.ClientConn
 	ChainName    string
 	timeout      time.Duration
 }
@@ -62,6 +63,7 @@ func (xc *Client) connect() error {
 	}
 	xchainClient := pb.NewXchainClient(conn)
 	xc.xchainClient = xchainClient
+	xc.conn = conn
 	xc.timeout = 60 * time.Second
 
 	return nil
@@ -272,6 +274,24 @@ func (xc *Client) QueryTx(txid string) (*pb.TxStatus, error) {
 }
 
 func (xc *Client) QueryACL(accountName string) (*pb.AclStatus, bool, error) {
+	in := &pb.AclStatus{
+		Bcname:      xc.ChainName,
+		AccountName: accountName,
+	}
+	return xc.queryACL(in)
+}
+
+//QueryMethodACL 查询合约方法的ACL
+func (xc *Client) QueryMethodACL(contractName, methodName string) (*pb.AclStatus, bool, error) {
+	in := &pb.AclStatus{
+		Bcname:       xc.ChainName,
+		ContractName: contractName,
+		MethodName:   methodName,
+	}
+	return xc.queryACL(in)
+}
+
+func (xc *Client) queryACL(in *pb.AclStatus) (*pb.AclStatus, bool, error) {
 	if cErr := xc.connect(); cErr != nil {
 		return nil, false, cErr
 	}
@@ -279,10 +299,6 @@ func (xc *Client) QueryACL(accountName string) (*pb.AclStatus, bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
 	defer cancel()
 
-	in := &pb.AclStatus{
-		Bcname:      xc.ChainName,
-		AccountName: accountName,
-	}
 	res, err := xc.xchainClient.QueryACL(ctx, in)
 	if err != nil {
 		return nil, false, err
@@ -299,7 +315,8 @@ func (xc *Client) QueryACL(accountName string) (*pb.AclStatus, bool, error) {
 
 }
 
-func (xc *Client) PreExec(in *pb.InvokeRPCRequest) (*pb.InvokeRPCResponse, error) {
+//GetAccountContracts 查询合约账户下的合约
+func (xc *Client) GetAccountContracts(accountName string) ([]*pb.ContractStatus, error) {
 	if cErr := xc.connect(); cErr != nil {
 		return nil, cErr
 	}
@@ -307,15 +324,193 @@ func (xc *Client) PreExec(in *pb.InvokeRPCRequest) (*pb.InvokeRPCResponse, error
 	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
 	defer cancel()
 
-	res, err := xc.xchainClient.PreExec(ctx, in)
+	in := &pb.GetAccountContractsRequest{
+		Bcname:  xc.ChainName,
+		Account: accountName,
+	}
+	res, err := xc.xchainClient.GetAccountContracts(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
+		return nil, fmt.Errorf(res.Header.Error.String())
+	}
+
+	return res.GetContractsStatus(), nil
+}
+
+//DposCandidates 查询TDPOS的候选人列表
+func (xc *Client) DposCandidates() ([]*pb.CandidateInfo, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return nil, cErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
+	defer cancel()
+
+	in := &pb.DposCandidatesRequest{
+		Bcname: xc.ChainName,
+	}
+	res, err := xc.xchainClient.DposCandidates(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
+		return nil, fmt.Errorf(res.Header.Error.String())
+	}
+
+	return res.GetCandidatesInfo(), nil
+}
+
+//DposNominateRecords 查询地址提名候选人的记录
+func (xc *Client) DposNominateRecords(address string) ([]*pb.DposNominateInfo, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return nil, cErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
+	defer cancel()
+
+	in := &pb.DposNominateRecordsRequest{
+		Bcname:  xc.ChainName,
+		Address: address,
+	}
+	res, err := xc.xchainClient.DposNominateRecords(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
+		return nil, fmt.Errorf(res.Header.Error.String())
+	}
+
+	return res.GetNominateRecords(), nil
+}
+
+//DposNomineeRecords 查询候选人被提名的交易ID
+func (xc *Client) DposNomineeRecords(address string) (string, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return "", cErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
+	defer cancel()
+
+	in := &pb.DposNomineeRecordsRequest{
+		Bcname:  xc.ChainName,
+		Address: address,
+	}
+	res, err := xc.xchainClient.DposNomineeRecords(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
+		return "", fmt.Errorf(res.Header.Error.String())
+	}
+
+	return res.GetTxid(), nil
+}
+
+//DposVoteRecords 查询地址的投票记录
+func (xc *Client) DposVoteRecords(address string) ([]*pb.VoteRecord, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return nil, cErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
+	defer cancel()
+
+	in := &pb.DposVoteRecordsRequest{
+		Bcname:  xc.ChainName,
+		Address: address,
+	}
+	res, err := xc.xchainClient.DposVoteRecords(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
+		return nil, fmt.Errorf(res.Header.Error.String())
+	}
+
+	return res.GetVoteTxidRecords(), nil
+}
+
+//DposVotedRecords 查询候选人被投票的记录
+func (xc *Client) DposVotedRecords(address string) ([]*pb.VotedRecord, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return nil, cErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
+	defer cancel()
+
+	in := &pb.DposVotedRecordsRequest{
+		Bcname:  xc.ChainName,
+		Address: address,
+	}
+	res, err := xc.xchainClient.DposVotedRecords(ctx, in)
 	if err != nil {
 		return nil, err
 	}
+	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
+		return nil, fmt.Errorf(res.Header.Error.String())
+	}
+
+	return res.GetVotedTxidRecords(), nil
+}
+
+//DposCheckResults 查询某一轮次的验证人
+func (xc *Client) DposCheckResults(term int64) ([]string, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return nil, cErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
+	defer cancel()
+
+	in := &pb.DposCheckResultsRequest{
+		Bcname: xc.ChainName,
+		Term:   term,
+	}
+	res, err := xc.xchainClient.DposCheckResults(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
+		return nil, fmt.Errorf(res.Header.Error.String())
+	}
+
+	return res.GetCheckResult(), nil
+}
+
+//DposStatus 查询TDPOS当前的轮次和验证人
+func (xc *Client) DposStatus() (*pb.DposStatus, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return nil, cErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
+	defer cancel()
 
+	in := &pb.DposStatusRequest{
+		Bcname: xc.ChainName,
+	}
+	res, err := xc.xchainClient.DposStatus(ctx, in)
+	if err != nil {
+		return nil, err
+	}
 	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
 		return nil, fmt.Errorf(res.Header.Error.String())
 	}
 
+	return res.GetStatus(), nil
+}
+
+func (xc *Client) PreExec(in *pb.InvokeRPCRequest) (*pb.InvokeRPCResponse, error) {
+	res, err := xc.PreExecWithStatus(in)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, res := range res.GetResponse().GetResponses() {
 		if res.Status >= 400 {
 			return nil, fmt.Errorf("contract error status:%d message:%s", res.Status, res.Message)
@@ -325,6 +520,27 @@ func (xc *Client) PreExec(in *pb.InvokeRPCRequest) (*pb.InvokeRPCResponse, error
 
 }
 
+//PreExecWithStatus 预执行合约，不检查合约的返回状态，由调用方处理
+func (xc *Client) PreExecWithStatus(in *pb.InvokeRPCRequest) (*pb.InvokeRPCResponse, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return nil, cErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), xc.timeout)
+	defer cancel()
+
+	res, err := xc.xchainClient.PreExec(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Header.Error != pb.XChainErrorEnum_SUCCESS {
+		return nil, fmt.Errorf(res.Header.Error.String())
+	}
+
+	return res, nil
+}
+
 func (xc *Client) PreExecWithSelectUTXO(in *pb.PreExecWithSelectUTXORequest) (*pb.PreExecWithSelectUTXOResponse, error) {
 	if cErr := xc.connect(); cErr != nil {
 		return nil, cErr
@@ -139,6 +139,40 @@ func TestClient_QueryACL(t *testing.T) {
 	fmt.Printf("\n %s \n", string(objJson))
 }
 
+func TestClient_GetAccountContracts(t *testing.T) {
+	contracts, err := tc.GetAccountContracts("XC3333333333333333@xuper")
+	if err != nil {
+		t.Errorf("GetAccountContracts failed, err: %v", err)
+		return
+	}
+	for _, c := range contracts {
+		log.Infof("contract: %+v", c)
+	}
+}
+
+func TestClient_DposCandidates(t *testing.T) {
+	candidates, err := tc.DposCandidates()
+	if err != nil {
+		t.Errorf("DposCandidates failed, err: %v", err)
+		return
+	}
+	for _, c := range candidates {
+		log.Infof("candidate: %+v", c)
+	}
+}
+
+func TestClient_DposVoteRecords(t *testing.T) {
+	address := "UbFfJuN4U6SqLcVGmJ2kUmgj59sHAd1a5"
+	records, err := tc.DposVoteRecords(address)
+	if err != nil {
+		t.Errorf("DposVoteRecords failed, err: %v", err)
+		return
+	}
+	for _, r := range records {
+		log.Infof("vote record: %+v", r)
+	}
+}
+
 func TestClient_SelectUTXO(t *testing.T) {
 	address := "UbFfJuN4U6SqLcVGmJ2kUmgj59sHAd1a5"
 	utxo, err := tc.SelectUTXO(address, "10000000", false)
@@ -181,4 +215,26 @@ func TestDecodeAddr(t *testing.T) {
 	addr := "VUdiVjJ2QnFNRkg0dGVXN0dlYUV6MTludDdwQTVDdVQz"
 	addrBit, _ := base64.StdEncoding.DecodeString(addr)
 	log.Infof("addr: %s", string(addrBit))
-}
\ No newline at end of file
+}
+
+func TestClient_SubscribeBlocks(t *testing.T) {
+	sub, err := tc.SubscribeBlocks(1)
+	if err != nil {
+		t.Errorf("SubscribeBlocks failed, err: %v", err)
+		return
+	}
+	defer sub.Close()
+
+	for i := 0; i < 3; i++ {
+		block, recvErr := sub.Recv()
+		if recvErr != nil {
+			if IsSubscribeUnsupported(recvErr) {
+				log.Infof("node does not support event service")
+				return
+			}
+			t.Errorf("Recv failed, err: %v", recvErr)
+			return
+		}
+		log.Infof("block: %d, hash: %s", block.BlockHeight, block.Blockid)
+	}
+}
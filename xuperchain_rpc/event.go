@@ -0,0 +1,188 @@
+/*
+ * Copyright 2019 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+package xuperchain_rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//当前依赖的xuperchain版本没有EventService，以下消息按节点event.proto的字段定义，通过通用的grpc流调用
+
+const (
+	eventSubscribeMethod = "/pb.EventService/Subscribe"
+
+	SubscribeTypeBlock int32 = 0 //订阅区块事件
+)
+
+//SubscribeRequest 订阅请求，Filter为序列化的BlockFilter
+type SubscribeRequest struct {
+	Type   int32  `protobuf:"varint,1,opt,name=type,proto3" json:"type,omitempty"`
+	Filter []byte `protobuf:"bytes,2,opt,name=filter,proto3" json:"filter,omitempty"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+
+//Event 订阅的事件，Payload为序列化的FilteredBlock
+type Event struct {
+	Payload []byte `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+
+//BlockRange 订阅的区块范围，End为空时持续订阅新区块
+type BlockRange struct {
+	Start string `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End   string `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+}
+
+func (m *BlockRange) Reset()         { *m = BlockRange{} }
+func (m *BlockRange) String() string { return proto.CompactTextString(m) }
+func (*BlockRange) ProtoMessage()    {}
+
+//BlockFilter 服务端的区块过滤条件
+type BlockFilter struct {
+	Bcname         string      `protobuf:"bytes,1,opt,name=bcname,proto3" json:"bcname,omitempty"`
+	Range          *BlockRange `protobuf:"bytes,2,opt,name=range,proto3" json:"range,omitempty"`
+	ExcludeTx      bool        `protobuf:"varint,3,opt,name=exclude_tx,json=excludeTx,proto3" json:"exclude_tx,omitempty"`
+	ExcludeTxEvent bool        `protobuf:"varint,4,opt,name=exclude_tx_event,json=excludeTxEvent,proto3" json:"exclude_tx_event,omitempty"`
+	Contract       string      `protobuf:"bytes,10,opt,name=contract,proto3" json:"contract,omitempty"`
+	EventName      string      `protobuf:"bytes,11,opt,name=event_name,json=eventName,proto3" json:"event_name,omitempty"`
+	Initiator      string      `protobuf:"bytes,12,opt,name=initiator,proto3" json:"initiator,omitempty"`
+	AuthRequire    string      `protobuf:"bytes,13,opt,name=auth_require,json=authRequire,proto3" json:"auth_require,omitempty"`
+	FromAddr       string      `protobuf:"bytes,14,opt,name=from_addr,json=fromAddr,proto3" json:"from_addr,omitempty"`
+	ToAddr         string      `protobuf:"bytes,15,opt,name=to_addr,json=toAddr,proto3" json:"to_addr,omitempty"`
+}
+
+func (m *BlockFilter) Reset()         { *m = BlockFilter{} }
+func (m *BlockFilter) String() string { return proto.CompactTextString(m) }
+func (*BlockFilter) ProtoMessage()    {}
+
+//ContractEvent 合约事件
+type ContractEvent struct {
+	Contract string `protobuf:"bytes,1,opt,name=contract,proto3" json:"contract,omitempty"`
+	Name     string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Body     []byte `protobuf:"bytes,3,opt,name=body,proto3" json:"body,omitempty"`
+}
+
+func (m *ContractEvent) Reset()         { *m = ContractEvent{} }
+func (m *ContractEvent) String() string { return proto.CompactTextString(m) }
+func (*ContractEvent) ProtoMessage()    {}
+
+//FilteredTransaction 过滤后的交易
+type FilteredTransaction struct {
+	Txid   string           `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Events []*ContractEvent `protobuf:"bytes,2,rep,name=events,proto3" json:"events,omitempty"`
+}
+
+func (m *FilteredTransaction) Reset()         { *m = FilteredTransaction{} }
+func (m *FilteredTransaction) String() string { return proto.CompactTextString(m) }
+func (*FilteredTransaction) ProtoMessage()    {}
+
+//FilteredBlock 过滤后的区块
+type FilteredBlock struct {
+	Bcname      string                 `protobuf:"bytes,1,opt,name=bcname,proto3" json:"bcname,omitempty"`
+	Blockid     string                 `protobuf:"bytes,2,opt,name=blockid,proto3" json:"blockid,omitempty"`
+	BlockHeight int64                  `protobuf:"varint,3,opt,name=block_height,json=blockHeight,proto3" json:"block_height,omitempty"`
+	Txs         []*FilteredTransaction `protobuf:"bytes,4,rep,name=txs,proto3" json:"txs,omitempty"`
+}
+
+func (m *FilteredBlock) Reset()         { *m = FilteredBlock{} }
+func (m *FilteredBlock) String() string { return proto.CompactTextString(m) }
+func (*FilteredBlock) ProtoMessage()    {}
+
+//BlockSubscription 区块事件订阅
+type BlockSubscription struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+//Recv 接收下一个区块事件，订阅断开时返回错误
+func (sub *BlockSubscription) Recv() (*FilteredBlock, error) {
+
+	event := &Event{}
+	if err := sub.stream.RecvMsg(event); err != nil {
+		return nil, err
+	}
+
+	block := &FilteredBlock{}
+	if err := proto.Unmarshal(event.Payload, block); err != nil {
+		return nil, fmt.Errorf("decode block event failed, err: %v", err)
+	}
+
+	return block, nil
+}
+
+//Close 取消订阅
+func (sub *BlockSubscription) Close() {
+	sub.cancel()
+}
+
+//SubscribeBlocks 通过节点的EventService订阅从startHeight开始的区块，只返回区块头，不包含交易
+func (xc *Client) SubscribeBlocks(startHeight int64) (*BlockSubscription, error) {
+	if cErr := xc.connect(); cErr != nil {
+		return nil, cErr
+	}
+
+	filter := &BlockFilter{
+		Bcname: xc.ChainName,
+		Range: &BlockRange{
+			Start: fmt.Sprintf("%d", startHeight),
+		},
+		ExcludeTx: true,
+	}
+	filterBytes, err := proto.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &SubscribeRequest{
+		Type:   SubscribeTypeBlock,
+		Filter: filterBytes,
+	}
+
+	//订阅持续到Close，不设置超时
+	ctx, cancel := context.WithCancel(context.Background())
+
+	desc := &grpc.StreamDesc{StreamName: "Subscribe", ServerStreams: true}
+	stream, err := xc.conn.NewStream(ctx, desc, eventSubscribeMethod)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err = stream.SendMsg(in); err != nil {
+		cancel()
+		return nil, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &BlockSubscription{stream: stream, cancel: cancel}, nil
+}
+
+//IsSubscribeUnsupported 节点是否不支持EventService
+func IsSubscribeUnsupported(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}